@@ -62,7 +62,7 @@ fi
 # Build mock server for cmd/main.go if it exists
 if [ -f "cmd/main.go" ]; then
     echo -e "\n${BLUE}Building mock server...${NC}"
-    CGO_ENABLED=0 GOOS=linux GOARCH=$GOARCH go build -a -ldflags '-extldflags "-static"' -o mock-server cmd/main.go
+    CGO_ENABLED=0 GOOS=linux GOARCH=$GOARCH go build -a -ldflags '-extldflags "-static"' -o mock-server ./cmd
     if [ $? -eq 0 ]; then
         echo -e "${GREEN}✓ mock-server built successfully${NC}"
     else
@@ -73,7 +73,7 @@ fi
 # Build capture proxy if it exists
 if [ -f "cmd/capture/main.go" ]; then
     echo -e "\n${BLUE}Building capture proxy...${NC}"
-    CGO_ENABLED=0 GOOS=linux GOARCH=$GOARCH go build -a -ldflags '-extldflags "-static"' -o capture-proxy cmd/capture/main.go
+    CGO_ENABLED=0 GOOS=linux GOARCH=$GOARCH go build -a -ldflags '-extldflags "-static"' -o capture-proxy ./cmd/capture
     if [ $? -eq 0 ]; then
         echo -e "${GREEN}✓ capture-proxy built successfully${NC}"
     else
@@ -47,7 +47,7 @@ export STATEMENTS_API_V2_URL="https://statements-api.example.com"
 export AUTHORISATIONS_API_URL="https://api-authorizations.example.com"
 
 cd mock-api-server
-go run cmd/capture/main.go
+go run ./cmd/capture
 EOF
     chmod +x run-capture-proxy.sh
     echo "Created: run-capture-proxy.sh"
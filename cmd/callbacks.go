@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CallbackConfig is a request the mock sends once it has answered the route,
+// emulating a provider's asynchronous webhook. URL, Headers and the strings
+// in Body are templates (see callbackContext.render), so the target can come
+// from the request, e.g. "{{request.body.callback_url}}". Delay is in
+// milliseconds; failed attempts (transport errors, 429 and 5xx) are retried
+// according to Retry, waiting for the receiver's Retry-After when it sends
+// one.
+type CallbackConfig struct {
+	Method  string            `json:"method,omitempty"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+	Delay   int               `json:"delay,omitempty"`
+	Retry   *CallbackRetry    `json:"retry,omitempty"`
+}
+
+// CallbackRetry allows up to Attempts tries in total, waiting BackoffMs
+// before the first retry and doubling the wait after each one.
+type CallbackRetry struct {
+	Attempts  int `json:"attempts"`
+	BackoffMs int `json:"backoff_ms,omitempty"`
+}
+
+func (config *CallbackConfig) validate() error {
+	if config.URL == "" {
+		return fmt.Errorf("callback needs a url")
+	}
+	if config.Delay < 0 {
+		return fmt.Errorf("invalid callback delay %d", config.Delay)
+	}
+	if config.Retry != nil && (config.Retry.Attempts < 0 || config.Retry.BackoffMs < 0) {
+		return fmt.Errorf("callback retry values must not be negative")
+	}
+	return nil
+}
+
+// callbackClient sends every callback; a hung receiver only holds up its own
+// attempt.
+var callbackClient = &http.Client{Timeout: 10 * time.Second}
+
+// CallbackOutcome records how a callback went, for the log and
+// GET /api/callbacks.
+type CallbackOutcome struct {
+	Route    string    `json:"route"`
+	Method   string    `json:"method"`
+	URL      string    `json:"url"`
+	Attempts int       `json:"attempts"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Success  bool      `json:"success"`
+	SentAt   time.Time `json:"sent_at"`
+}
+
+// maxCallbackOutcomes bounds the history kept for GET /api/callbacks.
+const maxCallbackOutcomes = 100
+
+// callbackLog keeps the most recent callback outcomes.
+type callbackLog struct {
+	mu       sync.Mutex
+	outcomes []CallbackOutcome
+}
+
+func (l *callbackLog) add(outcome CallbackOutcome) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.outcomes = append(l.outcomes, outcome)
+	if len(l.outcomes) > maxCallbackOutcomes {
+		l.outcomes = l.outcomes[len(l.outcomes)-maxCallbackOutcomes:]
+	}
+}
+
+func (l *callbackLog) list() []CallbackOutcome {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	outcomes := make([]CallbackOutcome, len(l.outcomes))
+	copy(outcomes, l.outcomes)
+	return outcomes
+}
+
+// callbackContext is what callback templates can refer to.
+type callbackContext struct {
+	method   string
+	path     string
+	params   map[string]string
+	query    url.Values
+	header   http.Header
+	body     interface{}
+	response interface{}
+}
+
+// newCallbackContext snapshots the request, since callbacks run after the
+// handler has returned. The body is left readable.
+func newCallbackContext(c echo.Context, params map[string]string, response interface{}) *callbackContext {
+	r := c.Request()
+	ctx := &callbackContext{
+		method:   r.Method,
+		path:     r.URL.Path,
+		params:   params,
+		query:    r.URL.Query(),
+		header:   r.Header.Clone(),
+		response: response,
+	}
+	if r.Body != nil {
+		data, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		if json.Unmarshal(data, &ctx.body) != nil && len(data) > 0 {
+			ctx.body = string(data)
+		}
+	}
+	return ctx
+}
+
+var templatePattern = regexp.MustCompile(`\{\{\s*([^{}]+?)\s*\}\}`)
+
+// lookup resolves a template name: request.method, request.path,
+// request.header.<Name>, request.query.<name>, request.body.<field...>,
+// response.body.<field...>, uuid, now (RFC 3339), timestamp (Unix seconds)
+// or a path parameter.
+func (ctx *callbackContext) lookup(name string) (interface{}, bool) {
+	switch {
+	case name == "request.method":
+		return ctx.method, true
+	case name == "request.path":
+		return ctx.path, true
+	case name == "uuid":
+		return newUUID(), true
+	case name == "now":
+		return time.Now().UTC().Format(time.RFC3339), true
+	case name == "timestamp":
+		return time.Now().Unix(), true
+	case strings.HasPrefix(name, "request.header."):
+		value := ctx.header.Get(strings.TrimPrefix(name, "request.header."))
+		return value, value != ""
+	case strings.HasPrefix(name, "request.query."):
+		values, ok := ctx.query[strings.TrimPrefix(name, "request.query.")]
+		if !ok || len(values) == 0 {
+			return nil, false
+		}
+		return values[0], true
+	case name == "request.body":
+		return ctx.body, ctx.body != nil
+	case strings.HasPrefix(name, "request.body."):
+		return lookupField(ctx.body, strings.Split(strings.TrimPrefix(name, "request.body."), "."))
+	case name == "response.body":
+		return ctx.response, ctx.response != nil
+	case strings.HasPrefix(name, "response.body."):
+		return lookupField(ctx.response, strings.Split(strings.TrimPrefix(name, "response.body."), "."))
+	}
+	value, ok := ctx.params[name]
+	return value, ok
+}
+
+// lookupField follows object keys and array indexes into a JSON value.
+func lookupField(value interface{}, fields []string) (interface{}, bool) {
+	for _, field := range fields {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[field]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(field)
+			if err != nil || index < 0 || index >= len(v) {
+				return nil, false
+			}
+			value = v[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// render fills the placeholders in template. Unknown names are left as they
+// are, like path placeholders in responses.
+func (ctx *callbackContext) render(template string) string {
+	return templatePattern.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := ctx.lookup(templatePattern.FindStringSubmatch(placeholder)[1])
+		if !ok {
+			return placeholder
+		}
+		return templateString(value)
+	})
+}
+
+// renderURL is render for the callback URL. Values substituted into the path
+// or query are escaped so they stay one segment or parameter; placeholders
+// before the path, such as a whole "{{request.body.callback_url}}" or a
+// base URL, are inserted as they are.
+func (ctx *callbackContext) renderURL(template string) string {
+	start := 0
+	if i := strings.Index(template, "://"); i >= 0 {
+		start = i + len("://")
+	}
+	pathStart := len(template)
+	if i := strings.IndexAny(template[start:], "/?#"); i >= 0 {
+		pathStart = start + i
+	}
+	queryStart := strings.IndexByte(template[pathStart:], '?')
+	if queryStart >= 0 {
+		queryStart += pathStart
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range templatePattern.FindAllStringSubmatchIndex(template, -1) {
+		b.WriteString(template[last:match[0]])
+		last = match[1]
+		value, ok := ctx.lookup(template[match[2]:match[3]])
+		switch {
+		case !ok:
+			b.WriteString(template[match[0]:match[1]])
+		case match[0] < pathStart:
+			b.WriteString(templateString(value))
+		case queryStart >= 0 && match[0] > queryStart:
+			b.WriteString(url.QueryEscape(templateString(value)))
+		default:
+			b.WriteString(url.PathEscape(templateString(value)))
+		}
+	}
+	b.WriteString(template[last:])
+	return b.String()
+}
+
+// templateString is how a value is written into a template: strings as they
+// are and anything else as JSON.
+func templateString(value interface{}) string {
+	if s, isString := value.(string); isString {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// renderValue renders every string in a JSON value. A string that is
+// nothing but one placeholder takes the referenced value with its JSON type,
+// so "{{request.body.amount}}" stays a number.
+func (ctx *callbackContext) renderValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if match := templatePattern.FindStringSubmatch(v); match != nil && match[0] == v {
+			if resolved, ok := ctx.lookup(match[1]); ok {
+				return resolved
+			}
+		}
+		return ctx.render(v)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered[key] = ctx.renderValue(item)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered[i] = ctx.renderValue(item)
+		}
+		return rendered
+	}
+	return value
+}
+
+// scheduleCallbacks sends the route's callbacks in the background.
+func (ms *MockServer) scheduleCallbacks(key string, route *RouteConfig, ctx *callbackContext) {
+	for _, callback := range route.Callbacks {
+		go ms.sendCallback(key, callback, ctx)
+	}
+}
+
+func (ms *MockServer) sendCallback(key string, config CallbackConfig, ctx *callbackContext) {
+	if config.Delay > 0 {
+		time.Sleep(time.Duration(config.Delay) * time.Millisecond)
+	}
+
+	method := strings.ToUpper(config.Method)
+	if method == "" {
+		method = http.MethodPost
+	}
+	outcome := CallbackOutcome{Route: key, Method: method, URL: ctx.renderURL(config.URL)}
+
+	var body []byte
+	contentType := ""
+	switch rendered := ctx.renderValue(config.Body).(type) {
+	case nil:
+	case string:
+		body = []byte(rendered)
+		contentType = "text/plain; charset=utf-8"
+		if json.Valid(body) {
+			contentType = echo.MIMEApplicationJSON
+		}
+	default:
+		body, _ = json.Marshal(rendered)
+		contentType = echo.MIMEApplicationJSON
+	}
+
+	attempts, backoff := 1, time.Second
+	if config.Retry != nil {
+		if config.Retry.Attempts > 1 {
+			attempts = config.Retry.Attempts
+		}
+		if config.Retry.BackoffMs > 0 {
+			backoff = time.Duration(config.Retry.BackoffMs) * time.Millisecond
+		}
+	}
+
+	var retryAfter time.Duration
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			wait := backoff
+			if retryAfter > 0 {
+				wait = retryAfter
+			}
+			time.Sleep(wait)
+			backoff *= 2
+		}
+		outcome.Attempts, outcome.SentAt = attempt, time.Now()
+
+		req, err := http.NewRequest(method, outcome.URL, bytes.NewReader(body))
+		if err != nil {
+			outcome.Error = err.Error()
+			break
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		for name, value := range config.Headers {
+			req.Header.Set(name, ctx.render(value))
+		}
+
+		resp, err := callbackClient.Do(req)
+		if err != nil {
+			outcome.Status, outcome.Error = 0, err.Error()
+			log.Printf("📨 Callback %s %s failed (attempt %d/%d): %v", method, outcome.URL, attempt, attempts, err)
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		outcome.Status, outcome.Error = resp.StatusCode, ""
+		if resp.StatusCode < 300 {
+			outcome.Success = true
+			break
+		}
+		log.Printf("📨 Callback %s %s got %d (attempt %d/%d)", method, outcome.URL, resp.StatusCode, attempt, attempts)
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+			break
+		}
+		retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	}
+
+	if outcome.Success {
+		log.Printf("📨 Callback %s %s -> %d", method, outcome.URL, outcome.Status)
+	} else {
+		log.Printf("📨 Callback %s %s gave up after %d attempt(s)", method, outcome.URL, outcome.Attempts)
+	}
+	ms.callbacks.add(outcome)
+}
+
+// maxCallbackRetryAfter caps how long a receiver's Retry-After can hold up
+// a callback.
+const maxCallbackRetryAfter = 5 * time.Minute
+
+// parseRetryAfter reads a Retry-After header in seconds or as an HTTP date.
+// It returns 0 when the header is missing or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	var wait time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		wait = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		wait = date.Sub(now)
+	}
+	if wait < 0 {
+		return 0
+	}
+	if wait > maxCallbackRetryAfter {
+		return maxCallbackRetryAfter
+	}
+	return wait
+}
+
+// handleListCallbacks returns the outcomes of recent callbacks, oldest
+// first.
+func (ms *MockServer) handleListCallbacks(c echo.Context) error {
+	return c.JSON(http.StatusOK, ms.callbacks.list())
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
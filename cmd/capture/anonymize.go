@@ -0,0 +1,538 @@
+package main
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"math/rand"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AnonymizeRule overrides or extends the field-based detection: every value
+// selected by JSONPath is replaced with a fake value of Type.
+type AnonymizeRule struct {
+	JSONPath string `json:"json_path"`
+	Type     string `json:"type"`
+
+	path []pathSegment
+}
+
+// AnonymizeConfig is the file format read from ANONYMIZE_CONFIG. Fields maps
+// JSON keys (matched case-insensitively, ignoring "_" and "-") to a fake
+// value type: name, first_name, last_name, email, phone, pan, sort_code,
+// iban, address, postcode, date or id. "id" and any unknown type keep the
+// original format, replacing letters with letters and digits with digits.
+type AnonymizeConfig struct {
+	Fields          map[string]string `json:"fields,omitempty"`
+	Rules           []AnonymizeRule   `json:"rules,omitempty"`
+	DisableDefaults bool              `json:"disable_defaults,omitempty"`
+}
+
+var defaultAnonymizeFields = map[string]string{
+	"name":           "name",
+	"fullname":       "name",
+	"displayname":    "name",
+	"cardholdername": "name",
+	"firstname":      "first_name",
+	"givenname":      "first_name",
+	"lastname":       "last_name",
+	"surname":        "last_name",
+	"familyname":     "last_name",
+	"email":          "email",
+	"emailaddress":   "email",
+	"phone":          "phone",
+	"phonenumber":    "phone",
+	"mobile":         "phone",
+	"accountnumber":  "id",
+	"cardnumber":     "pan",
+	"pan":            "pan",
+	"iban":           "iban",
+	"sortcode":       "sort_code",
+	"address":        "address",
+	"addressline1":   "address",
+	"street":         "address",
+	"postcode":       "postcode",
+	"postalcode":     "postcode",
+	"zipcode":        "postcode",
+	"dateofbirth":    "date",
+	"dob":            "date",
+	"birthdate":      "date",
+}
+
+var (
+	fakeFirstNames = []string{"Alex", "Sam", "Jordan", "Taylor", "Morgan", "Casey", "Jamie", "Robin", "Charlie", "Drew", "Avery", "Quinn", "Riley", "Rowan", "Sky", "Emery"}
+	fakeLastNames  = []string{"Smith", "Jones", "Taylor", "Brown", "Williams", "Wilson", "Johnson", "Davies", "Patel", "Wright", "Walker", "Green", "Hall", "Wood", "Clarke", "Hughes"}
+	fakeStreets    = []string{"High Street", "Station Road", "Church Lane", "Park Avenue", "Mill Road", "Victoria Road", "Green Lane", "Manor Way"}
+)
+
+// Anonymizer replaces personal data with fake values. The fake value is
+// derived from an HMAC of the original, so the same input gets the same fake
+// value everywhere, which keeps IDs and names consistent between related
+// responses such as /customers/{id} and /accounts.
+type Anonymizer struct {
+	key    []byte
+	fields map[string]string
+	rules  []AnonymizeRule
+
+	mu sync.Mutex
+	// Fake values by type and original value
+	mappings map[string]string
+	// Fake values of identifiers by original value, for URLs
+	identifiers map[string]string
+}
+
+// freeTextTypes are fake value types that are not identifiers. Their
+// values are common words ("Savings", "London"), so they are not looked for
+// in URLs where they would rewrite literal path segments.
+var freeTextTypes = map[string]bool{
+	"name":       true,
+	"first_name": true,
+	"last_name":  true,
+	"address":    true,
+}
+
+func NewAnonymizer(config AnonymizeConfig, key []byte) (*Anonymizer, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := crand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	a := &Anonymizer{
+		key:         key,
+		fields:      make(map[string]string),
+		mappings:    make(map[string]string),
+		identifiers: make(map[string]string),
+	}
+
+	if !config.DisableDefaults {
+		for field, fakeType := range defaultAnonymizeFields {
+			a.fields[field] = fakeType
+		}
+	}
+	for field, fakeType := range config.Fields {
+		a.fields[normalizeFieldName(field)] = fakeType
+	}
+
+	for i, rule := range config.Rules {
+		path, err := parseJSONPath(rule.JSONPath)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		rule.path = path
+		a.rules = append(a.rules, rule)
+	}
+	return a, nil
+}
+
+// LoadAnonymizer reads an AnonymizeConfig file; an empty path gives the
+// built-in fields. Fake values are consistent within a run; set ANONYMIZE_KEY
+// to keep them the same across runs.
+func LoadAnonymizer(configPath string) (*Anonymizer, error) {
+	var config AnonymizeConfig
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+		}
+	}
+	return NewAnonymizer(config, []byte(os.Getenv("ANONYMIZE_KEY")))
+}
+
+func normalizeFieldName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.ReplaceAll(name, "_", "")
+	return strings.ReplaceAll(name, "-", "")
+}
+
+// AnonymizeAll rewrites the bodies of every capture first and then replaces
+// any anonymized identifier that also appears in URLs, paths or query
+// parameters.
+func (a *Anonymizer) AnonymizeAll(captures []CapturedRoute) []CapturedRoute {
+	result := make([]CapturedRoute, len(captures))
+	for i, capture := range captures {
+		capture.Response = a.anonymizeBody(capture.Response)
+		capture.RequestBody = a.anonymizeBody(capture.RequestBody)
+		if capture.GRPC != nil {
+			call := *capture.GRPC
+			if call.Stream != nil {
+				stream := make([]interface{}, len(call.Stream))
+				for j, message := range call.Stream {
+					stream[j] = a.anonymizeBody(message)
+				}
+				call.Stream = stream
+			}
+			call.Trailers = a.anonymizeTrailers(call.Trailers)
+			capture.GRPC = &call
+		}
+		result[i] = capture
+	}
+
+	for i := range result {
+		result[i].Path = a.replacePathSegments(result[i].Path)
+		result[i].FullURL = a.replaceURL(result[i].FullURL)
+		if result[i].QueryParams != nil {
+			params := make(map[string]string, len(result[i].QueryParams))
+			for name, value := range result[i].QueryParams {
+				params[name] = a.mappedIdentifier(value)
+			}
+			result[i].QueryParams = params
+		}
+	}
+	return result
+}
+
+// anonymizeTrailers fakes gRPC trailer values whose names match a field
+// rule, e.g. an "x-user-email" trailer when "useremail" is configured.
+func (a *Anonymizer) anonymizeTrailers(trailers map[string]string) map[string]string {
+	if trailers == nil {
+		return nil
+	}
+	result := make(map[string]string, len(trailers))
+	for name, value := range trailers {
+		if fakeType, ok := a.fields[normalizeFieldName(strings.TrimPrefix(strings.ToLower(name), "x-"))]; ok {
+			value = a.fake(fakeType, value)
+		}
+		result[name] = value
+	}
+	return result
+}
+
+func (a *Anonymizer) anonymizeBody(body interface{}) interface{} {
+	if body == nil {
+		return nil
+	}
+	body = a.anonymizeFields(body)
+	for _, rule := range a.rules {
+		rule := rule
+		body = applyJSONPath(body, rule.path, func(value interface{}) interface{} {
+			return a.fakeValue(rule.Type, value)
+		})
+	}
+	return body
+}
+
+func (a *Anonymizer) anonymizeFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			if fakeType, ok := a.fields[normalizeFieldName(key)]; ok {
+				switch child.(type) {
+				case string, float64:
+					result[key] = a.fakeValue(fakeType, child)
+					continue
+				}
+			}
+			result[key] = a.anonymizeFields(child)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, child := range v {
+			result[i] = a.anonymizeFields(child)
+		}
+		return result
+	}
+	return value
+}
+
+// fakeValue anonymizes strings and numbers, keeping the JSON type.
+func (a *Anonymizer) fakeValue(fakeType string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return a.fake(fakeType, v)
+	case float64:
+		if v != float64(int64(v)) {
+			return v
+		}
+		fake := a.fake(fakeType, strconv.FormatInt(int64(v), 10))
+		if n, err := strconv.ParseInt(fake, 10, 64); err == nil {
+			return float64(n)
+		}
+		return v
+	case map[string]interface{}, []interface{}:
+		return walkStrings(v, func(s string) string { return a.fake(fakeType, s) })
+	}
+	return value
+}
+
+// fake returns the fake value for original, generating it on first use.
+func (a *Anonymizer) fake(fakeType, original string) string {
+	if original == "" {
+		return original
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	seed := fakeType + "\x00" + original
+	if fake, ok := a.mappings[seed]; ok {
+		return fake
+	}
+
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte(seed))
+	rng := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(mac.Sum(nil)))))
+
+	fake := generateFake(fakeType, original, rng)
+	a.mappings[seed] = fake
+	if _, seen := a.identifiers[original]; !seen && !freeTextTypes[fakeType] {
+		a.identifiers[original] = fake
+	}
+	return fake
+}
+
+// mappedIdentifier returns the fake value for a string that has been
+// anonymized as an identifier somewhere in the session, or the string
+// itself. Very short values are left alone so page numbers and flags are
+// not rewritten.
+func (a *Anonymizer) mappedIdentifier(s string) string {
+	if len(s) < 3 {
+		return s
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if fake, ok := a.identifiers[s]; ok {
+		return fake
+	}
+	return s
+}
+
+func (a *Anonymizer) replacePathSegments(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			if fake := a.mappedIdentifier(unescaped); fake != unescaped {
+				parts[i] = url.PathEscape(fake)
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (a *Anonymizer) replaceURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || rawURL == "" {
+		return rawURL
+	}
+
+	u.Path = a.replacePathSegments(u.Path)
+	u.RawPath = ""
+	if u.RawQuery != "" {
+		query := u.Query()
+		for _, values := range query {
+			for i, value := range values {
+				values[i] = a.mappedIdentifier(value)
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+func generateFake(fakeType, original string, rng *rand.Rand) string {
+	switch fakeType {
+	case "name":
+		first := fakeFirstNames[rng.Intn(len(fakeFirstNames))]
+		last := fakeLastNames[rng.Intn(len(fakeLastNames))]
+		if !strings.Contains(strings.TrimSpace(original), " ") {
+			return first
+		}
+		return first + " " + last
+	case "first_name":
+		return fakeFirstNames[rng.Intn(len(fakeFirstNames))]
+	case "last_name":
+		return fakeLastNames[rng.Intn(len(fakeLastNames))]
+	case "email":
+		first := fakeFirstNames[rng.Intn(len(fakeFirstNames))]
+		last := fakeLastNames[rng.Intn(len(fakeLastNames))]
+		return strings.ToLower(fmt.Sprintf("%s.%s%d@example.com", first, last, rng.Intn(1000)))
+	case "address":
+		return fmt.Sprintf("%d %s", rng.Intn(200)+1, fakeStreets[rng.Intn(len(fakeStreets))])
+	case "pan":
+		return fakePAN(original, rng)
+	case "iban":
+		return fakeIBAN(original, rng)
+	case "phone":
+		// Keep the country/area prefix so numbers still look local
+		return preserveFormat(original, rng, 3)
+	case "date":
+		return fakeDate(original, rng)
+	}
+	// id, sort_code, postcode and custom types keep their exact shape
+	return preserveFormat(original, rng, 0)
+}
+
+// preserveFormat replaces digits with digits and letters with letters of the
+// same case, leaving separators and the first keep characters untouched.
+func preserveFormat(original string, rng *rand.Rand, keep int) string {
+	out := []rune(original)
+	for i, r := range out {
+		if i < keep {
+			continue
+		}
+		switch {
+		case i == 0 && r >= '1' && r <= '9':
+			// No leading zero, so numeric IDs keep their value length
+			out[i] = rune('1' + rng.Intn(9))
+		case r >= '0' && r <= '9':
+			out[i] = rune('0' + rng.Intn(10))
+		case r >= 'a' && r <= 'z':
+			out[i] = rune('a' + rng.Intn(26))
+		case r >= 'A' && r <= 'Z':
+			out[i] = rune('A' + rng.Intn(26))
+		}
+	}
+	return string(out)
+}
+
+// fakePAN keeps the card network (first digit), length and separators and
+// fixes up the last digit so the number stays Luhn-valid.
+func fakePAN(original string, rng *rand.Rand) string {
+	out := []byte(original)
+	var positions []int
+	for i, c := range out {
+		if c >= '0' && c <= '9' {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) < 2 {
+		return preserveFormat(original, rng, 0)
+	}
+
+	for _, pos := range positions[1 : len(positions)-1] {
+		out[pos] = byte('0' + rng.Intn(10))
+	}
+
+	// Luhn: double every second digit from the right, excluding the check digit
+	sum := 0
+	for i, pos := range positions[:len(positions)-1] {
+		d := int(out[pos] - '0')
+		if (len(positions)-1-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	out[positions[len(positions)-1]] = byte('0' + (10-sum%10)%10)
+	return string(out)
+}
+
+// fakeIBAN keeps the country code and shape and recomputes the check digits.
+func fakeIBAN(original string, rng *rand.Rand) string {
+	compact := strings.ToUpper(strings.ReplaceAll(original, " ", ""))
+	if len(compact) < 5 {
+		return preserveFormat(original, rng, 0)
+	}
+
+	bban := preserveFormat(compact[4:], rng, 0)
+	numeric := ""
+	for _, r := range bban + compact[:2] + "00" {
+		if r >= 'A' && r <= 'Z' {
+			numeric += strconv.Itoa(int(r-'A') + 10)
+		} else {
+			numeric += string(r)
+		}
+	}
+	n, ok := new(big.Int).SetString(numeric, 10)
+	if !ok {
+		return preserveFormat(original, rng, 0)
+	}
+	check := 98 - int(new(big.Int).Mod(n, big.NewInt(97)).Int64())
+	iban := fmt.Sprintf("%s%02d%s", compact[:2], check, bban)
+
+	if !strings.Contains(original, " ") {
+		return iban
+	}
+	var groups []string
+	for i := 0; i < len(iban); i += 4 {
+		end := i + 4
+		if end > len(iban) {
+			end = len(iban)
+		}
+		groups = append(groups, iban[i:end])
+	}
+	return strings.Join(groups, " ")
+}
+
+// fakeDate moves a YYYY-MM-DD date (optionally followed by a time) to another
+// valid day, leaving any other format to preserveFormat.
+func fakeDate(original string, rng *rand.Rand) string {
+	if len(original) < 10 || original[4] != '-' || original[7] != '-' {
+		return preserveFormat(original, rng, 0)
+	}
+	year, err := strconv.Atoi(original[:4])
+	if err != nil {
+		return preserveFormat(original, rng, 0)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d%s", year-5+rng.Intn(11), rng.Intn(12)+1, rng.Intn(28)+1, original[10:])
+}
+
+// runAnonymize implements the "anonymize" command, which rewrites saved
+// capture files into shareable fixtures.
+func runAnonymize(args []string) error {
+	fs := flag.NewFlagSet("anonymize", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("ANONYMIZE_CONFIG"), "anonymization config file (default built-in fields)")
+	outDir := fs.String("out", "", "directory to write the anonymized files to")
+	fs.Parse(args)
+
+	if *outDir == "" || fs.NArg() == 0 {
+		return fmt.Errorf("usage: anonymize -out fixtures/ [-config anonymize.json] captured/*.json")
+	}
+
+	anonymizer, err := LoadAnonymizer(*configPath)
+	if err != nil {
+		return err
+	}
+
+	// Anonymize all files together so values stay consistent between them
+	var all []CapturedRoute
+	counts := make([]int, fs.NArg())
+	for i, file := range fs.Args() {
+		routes, err := loadCaptureFile(file)
+		if err != nil {
+			return err
+		}
+		counts[i] = len(routes)
+		all = append(all, routes...)
+	}
+	all = anonymizer.AnonymizeAll(all)
+
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+	offset := 0
+	for i, file := range fs.Args() {
+		routes := all[offset : offset+counts[i]]
+		offset += counts[i]
+
+		data, err := json.MarshalIndent(map[string][]CapturedRoute{"routes": routes}, "", "  ")
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(*outDir, filepath.Base(file))
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		log.Printf("Anonymized %d routes into %s", len(routes), target)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func newTestAnonymizer(t *testing.T, config AnonymizeConfig) *Anonymizer {
+	t.Helper()
+	a, err := NewAnonymizer(config, []byte("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAnonymizerIsDeterministic(t *testing.T) {
+	tests := []struct {
+		fakeType string
+		original string
+	}{
+		{"name", "Jane Doe"},
+		{"email", "jane@example.org"},
+		{"pan", "4111 1111 1111 1111"},
+		{"iban", "GB82WEST12345698765432"},
+		{"id", "cus_8f3k2"},
+		{"date", "1990-04-12"},
+		{"phone", "+44 7700 900123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fakeType, func(t *testing.T) {
+			first := newTestAnonymizer(t, AnonymizeConfig{}).fake(tt.fakeType, tt.original)
+			second := newTestAnonymizer(t, AnonymizeConfig{}).fake(tt.fakeType, tt.original)
+			if first != second {
+				t.Errorf("same key gave %q and %q", first, second)
+			}
+			if first == tt.original {
+				t.Errorf("%q was not changed", tt.original)
+			}
+		})
+	}
+}
+
+func TestAnonymizerKeysFakesByType(t *testing.T) {
+	a := newTestAnonymizer(t, AnonymizeConfig{})
+	asName := a.fake("name", "Morgan")
+	asID := a.fake("id", "Morgan")
+	if asName == asID {
+		t.Errorf("name and id fakes of the same value are both %q", asName)
+	}
+	if again := a.fake("name", "Morgan"); again != asName {
+		t.Errorf("name fake changed from %q to %q", asName, again)
+	}
+}
+
+func TestFakePANIsLuhnValid(t *testing.T) {
+	originals := []string{"4111111111111111", "5500 0000 0000 0004", "3400-000000-00009", "6011000990139424"}
+	for seed := int64(0); seed < 50; seed++ {
+		for _, original := range originals {
+			fake := fakePAN(original, rand.New(rand.NewSource(seed)))
+			if !luhnValid(fake) {
+				t.Fatalf("fakePAN(%q) = %q is not Luhn-valid", original, fake)
+			}
+			if len(fake) != len(original) || fake[0] != original[0] {
+				t.Fatalf("fakePAN(%q) = %q lost the shape or network", original, fake)
+			}
+			if strings.Count(fake, " ") != strings.Count(original, " ") || strings.Count(fake, "-") != strings.Count(original, "-") {
+				t.Fatalf("fakePAN(%q) = %q lost the separators", original, fake)
+			}
+		}
+	}
+}
+
+func TestPreserveFormat(t *testing.T) {
+	shape := func(s string) string {
+		s = regexp.MustCompile(`[0-9]`).ReplaceAllString(s, "9")
+		s = regexp.MustCompile(`[a-z]`).ReplaceAllString(s, "a")
+		return regexp.MustCompile(`[A-Z]`).ReplaceAllString(s, "A")
+	}
+	for _, original := range []string{"AB-123-cd", "1000", "acc_9f8e7d", "12-34-56"} {
+		fake := preserveFormat(original, rand.New(rand.NewSource(1)), 0)
+		if shape(fake) != shape(original) {
+			t.Errorf("preserveFormat(%q) = %q changed the shape", original, fake)
+		}
+	}
+	if fake := preserveFormat("1000", rand.New(rand.NewSource(2)), 0); fake[0] == '0' {
+		t.Errorf("preserveFormat(1000) = %q has a leading zero", fake)
+	}
+}
+
+func TestAnonymizeAllRewritesIdentifiersInURLs(t *testing.T) {
+	a := newTestAnonymizer(t, AnonymizeConfig{})
+	captures := a.AnonymizeAll([]CapturedRoute{{
+		Method:      "GET",
+		Path:        "/accounts/{id}",
+		FullURL:     "https://api.example.com/accounts/acc_12345?owner=accounts&ref=acc_12345",
+		QueryParams: map[string]string{"owner": "accounts", "ref": "acc_12345"},
+		Response:    map[string]interface{}{"name": "accounts", "account_number": "acc_12345"},
+	}})
+
+	response := captures[0].Response.(map[string]interface{})
+	fakeID := response["account_number"].(string)
+	if fakeID == "acc_12345" {
+		t.Fatal("account number was not anonymized")
+	}
+	want := "https://api.example.com/accounts/" + fakeID + "?owner=accounts&ref=" + fakeID
+	if captures[0].FullURL != want {
+		t.Errorf("FullURL = %q, want %q", captures[0].FullURL, want)
+	}
+	if captures[0].QueryParams["owner"] != "accounts" || captures[0].QueryParams["ref"] != fakeID {
+		t.Errorf("QueryParams = %v", captures[0].QueryParams)
+	}
+}
+
+func TestAnonymizeAllCoversGRPC(t *testing.T) {
+	a := newTestAnonymizer(t, AnonymizeConfig{})
+	captures := a.AnonymizeAll([]CapturedRoute{{
+		GRPC: &GRPCCapture{
+			Stream:   []interface{}{map[string]interface{}{"email": "jane@example.org"}},
+			Trailers: map[string]string{"x-email": "jane@example.org", "grpc-status": "0"},
+		},
+	}})
+	call := captures[0].GRPC
+	if call.Stream[0].(map[string]interface{})["email"] == "jane@example.org" {
+		t.Error("stream message was not anonymized")
+	}
+	if call.Trailers["x-email"] == "jane@example.org" || call.Trailers["grpc-status"] != "0" {
+		t.Errorf("Trailers = %v", call.Trailers)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// decodeBody undoes a Content-Encoding so that compressed bodies are stored
+// readable. Encodings are listed in the order they were applied, so they are
+// removed last to first.
+func decodeBody(data []byte, contentEncoding string) ([]byte, error) {
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+
+		var reader io.Reader
+		switch encoding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			gz, err := gzip.NewReader(bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			reader = gz
+		case "deflate":
+			// "deflate" is zlib-wrapped, but some servers send raw deflate
+			if zr, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+				reader = zr
+			} else {
+				reader = flate.NewReader(bytes.NewReader(data))
+			}
+		case "br":
+			reader = brotli.NewReader(bytes.NewReader(data))
+		default:
+			return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+		}
+
+		decoded, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s body: %w", encoding, err)
+		}
+		data = decoded
+	}
+	return data, nil
+}
+
+// decodedHeaders drops the headers that describe the encoded body once it
+// has been decoded for storage.
+func decodedHeaders(h http.Header) http.Header {
+	h = h.Clone()
+	h.Del("Content-Encoding")
+	h.Del("Content-Length")
+	return h
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// FilterRule matches captures. Every condition that is set must match. Host
+// is a glob matched against the host with and without its port, Path a
+// regular expression on the request path, Status a list of codes or classes
+// such as "404" and "5xx", and ContentType a glob on the response media type.
+type FilterRule struct {
+	Host        string   `json:"host,omitempty"`
+	Path        string   `json:"path,omitempty"`
+	Methods     []string `json:"methods,omitempty"`
+	Status      []string `json:"status,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// FilterConfig is the file format read from CAPTURE_FILTERS and accepted by
+// /capture/filters. When Include has rules a capture must match one of them;
+// a capture matching any Exclude rule is always dropped.
+type FilterConfig struct {
+	Include []FilterRule `json:"include,omitempty"`
+	Exclude []FilterRule `json:"exclude,omitempty"`
+}
+
+// CaptureFilter decides which captures are stored.
+type CaptureFilter struct {
+	config FilterConfig
+}
+
+func NewCaptureFilter(config FilterConfig) (*CaptureFilter, error) {
+	compile := func(kind string, rules []FilterRule) ([]FilterRule, error) {
+		rules = append([]FilterRule{}, rules...)
+		for i := range rules {
+			for _, status := range rules[i].Status {
+				if !validStatusPattern(status) {
+					return nil, fmt.Errorf("%s rule %d: invalid status %q", kind, i, status)
+				}
+			}
+			if rules[i].Path == "" {
+				continue
+			}
+			re, err := regexp.Compile(rules[i].Path)
+			if err != nil {
+				return nil, fmt.Errorf("%s rule %d: invalid path %q: %w", kind, i, rules[i].Path, err)
+			}
+			rules[i].re = re
+		}
+		return rules, nil
+	}
+
+	var err error
+	if config.Include, err = compile("include", config.Include); err != nil {
+		return nil, err
+	}
+	if config.Exclude, err = compile("exclude", config.Exclude); err != nil {
+		return nil, err
+	}
+	return &CaptureFilter{config: config}, nil
+}
+
+// LoadCaptureFilter reads a FilterConfig file. An empty path gives a filter
+// that keeps everything.
+func LoadCaptureFilter(configPath string) (*CaptureFilter, error) {
+	var config FilterConfig
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+		}
+	}
+	return NewCaptureFilter(config)
+}
+
+func (f *CaptureFilter) Config() FilterConfig {
+	return f.config
+}
+
+// Allow reports whether a capture should be stored.
+func (f *CaptureFilter) Allow(capture CapturedRoute) bool {
+	if len(f.config.Include) > 0 {
+		included := false
+		for i := range f.config.Include {
+			if f.config.Include[i].matches(capture) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for i := range f.config.Exclude {
+		if f.config.Exclude[i].matches(capture) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *FilterRule) matches(capture CapturedRoute) bool {
+	host, requestPath := capture.Host, capture.Path
+	if u, err := url.Parse(capture.FullURL); err == nil && u.Host != "" {
+		host, requestPath = u.Host, u.Path
+	}
+
+	if r.Host != "" && !hostMatches(r.Host, host) {
+		return false
+	}
+	if r.re != nil && !r.re.MatchString(requestPath) {
+		return false
+	}
+	if len(r.Methods) > 0 && !containsFold(r.Methods, capture.Method) {
+		return false
+	}
+	if len(r.Status) > 0 && !statusMatches(r.Status, capture.Status) {
+		return false
+	}
+	if r.ContentType != "" {
+		mediaType := strings.TrimSpace(strings.Split(headerValue(capture.ResponseHeaders, "Content-Type"), ";")[0])
+		if ok, _ := path.Match(strings.ToLower(r.ContentType), strings.ToLower(mediaType)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// hostMatches matches a host glob against host with and without its port.
+func hostMatches(pattern, host string) bool {
+	if ok, _ := path.Match(pattern, host); ok {
+		return true
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		ok, _ := path.Match(pattern, hostname)
+		return ok
+	}
+	return false
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func validStatusPattern(pattern string) bool {
+	if len(pattern) != 3 {
+		return false
+	}
+	if strings.HasSuffix(strings.ToLower(pattern), "xx") {
+		return pattern[0] >= '1' && pattern[0] <= '5'
+	}
+	_, err := strconv.Atoi(pattern)
+	return err == nil
+}
+
+func statusMatches(patterns []string, status int) bool {
+	code := strconv.Itoa(status)
+	for _, pattern := range patterns {
+		if pattern == code {
+			return true
+		}
+		if strings.HasSuffix(strings.ToLower(pattern), "xx") && len(code) == 3 && code[0] == pattern[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadFilterRules replaces the capture filter with the one in configPath.
+func (cp *CaptureProxy) LoadFilterRules(configPath string) error {
+	filter, err := LoadCaptureFilter(configPath)
+	if err != nil {
+		return err
+	}
+	cp.SetFilter(filter)
+	log.Printf("Loaded capture filters from %s", configPath)
+	return nil
+}
+
+func (cp *CaptureProxy) SetFilter(filter *CaptureFilter) {
+	cp.mu.Lock()
+	cp.filter = filter
+	cp.mu.Unlock()
+}
+
+func (cp *CaptureProxy) Filter() *CaptureFilter {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	return cp.filter
+}
+
+// WatchFilterRules reloads configPath whenever it changes. The directory is
+// watched so that editors which replace the file are picked up too.
+func (cp *CaptureProxy) WatchFilterRules(configPath string) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Failed to create filter watcher: %v", err)
+		return
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(filepath.Dir(configPath)); err != nil {
+		log.Printf("Failed to watch capture filters: %v", err)
+		return
+	}
+
+	log.Printf("Watching for changes in %s", configPath)
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(configPath) {
+				continue
+			}
+			if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
+				time.Sleep(100 * time.Millisecond)
+				if err := cp.LoadFilterRules(configPath); err != nil {
+					log.Printf("Error reloading capture filters: %v", err)
+				}
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watcher error: %v", err)
+		}
+	}
+}
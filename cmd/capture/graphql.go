@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+
+	"firecracker/mock-api-server/internal/graphql"
+)
+
+// GraphQLOperation identifies a captured GraphQL call. It is saved as the
+// route's "graphql" block, which the mock server uses as a matcher so that
+// operations sharing one endpoint replay separately. QueryHash (the SHA-256
+// of the query text, as used by persisted queries) is only recorded for
+// anonymous operations, so reformatting a named operation's query doesn't
+// stop it matching.
+type GraphQLOperation struct {
+	OperationName string `json:"operation_name,omitempty"`
+	OperationType string `json:"operation_type,omitempty"`
+	QueryHash     string `json:"query_hash,omitempty"`
+}
+
+// detectGraphQL recognizes GraphQL requests: a JSON body with a "query"
+// string (or a persisted query hash), or a GET with a query parameter.
+// It returns nil for anything else.
+func detectGraphQL(method string, params url.Values, body interface{}) *GraphQLOperation {
+	fields, _ := body.(map[string]interface{})
+	if method == "GET" {
+		// GET requests carry the same fields as query parameters, with
+		// extensions JSON-encoded
+		fields = map[string]interface{}{
+			"query":         params.Get("query"),
+			"operationName": params.Get("operationName"),
+		}
+		var extensions interface{}
+		if json.Unmarshal([]byte(params.Get("extensions")), &extensions) == nil {
+			fields["extensions"] = extensions
+		}
+	}
+
+	query, _ := fields["query"].(string)
+	operationName, _ := fields["operationName"].(string)
+	var hash string
+	if extensions, ok := fields["extensions"].(map[string]interface{}); ok {
+		if persisted, ok := extensions["persistedQuery"].(map[string]interface{}); ok {
+			hash, _ = persisted["sha256Hash"].(string)
+		}
+	}
+	if query == "" && hash == "" {
+		return nil
+	}
+
+	operation := &GraphQLOperation{OperationName: operationName}
+	if query != "" {
+		name, operationType := graphql.ParseOperation(query, operationName)
+		if operation.OperationName == "" {
+			operation.OperationName = name
+		}
+		operation.OperationType = operationType
+		if hash == "" {
+			sum := sha256.Sum256([]byte(query))
+			hash = hex.EncodeToString(sum[:])
+		}
+	}
+	if operation.OperationName == "" {
+		operation.QueryHash = hash
+	}
+	return operation
+}
+
+// graphQLDescription names a GraphQL capture after its operation.
+func graphQLDescription(operation *GraphQLOperation, serviceName string) string {
+	label := "GraphQL"
+	if operation.OperationType != "" {
+		label += " " + operation.OperationType
+	}
+	if operation.OperationName != "" {
+		label += " " + operation.OperationName
+	} else {
+		label += " (anonymous)"
+	}
+	return label + " captured from " + serviceName
+}
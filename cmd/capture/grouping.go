@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// GroupingRule assigns captures to a service group. Every condition that is
+// set must match; the first matching rule wins. Group is a template that may
+// use {{host}}, {{method}}, {{header}} (the value of Header), {{operation}}
+// (the GraphQL operation name) and {{1}}, {{2}}... for PathPattern submatches;
+// variables without a value expand to "". A rule whose group comes out empty
+// or as the reserved name "all" is skipped. Operation is a glob matched
+// against GraphQL operation names; "*" selects every GraphQL capture.
+type GroupingRule struct {
+	Host        string `json:"host,omitempty"`
+	PathPattern string `json:"path_pattern,omitempty"`
+	Header      string `json:"header,omitempty"`
+	Operation   string `json:"operation,omitempty"`
+	Group       string `json:"group"`
+
+	re *regexp.Regexp
+}
+
+// GroupingConfig is the file format read from GROUPING_CONFIG. Filename is a
+// template that must use {{group}} and may use {{date}}.
+type GroupingConfig struct {
+	Rules    []GroupingRule `json:"rules"`
+	Default  string         `json:"default,omitempty"`
+	Filename string         `json:"filename,omitempty"`
+}
+
+// defaultGroupingConfig reproduces the services the capture proxy has always
+// grouped by.
+var defaultGroupingConfig = GroupingConfig{
+	Rules: []GroupingRule{
+		{PathPattern: `/accounts`, Group: "accounts"},
+		{PathPattern: `/customers`, Group: "customers"},
+		{PathPattern: `/cards|/wallet`, Group: "cards"},
+		{PathPattern: `/ledger`, Group: "ledger"},
+		{PathPattern: `/statements`, Group: "statements"},
+		{PathPattern: `/authorizations`, Group: "authorizations"},
+		{PathPattern: `/users`, Group: "users"},
+		{PathPattern: `/posts`, Group: "posts"},
+		{Operation: "*", Group: "graphql"},
+	},
+	Default:  "misc",
+	Filename: "{{group}}-captured.json",
+}
+
+// reservedGroup is the name of the combined capture file (all-captured.json),
+// so no service group may use it.
+const reservedGroup = "all"
+
+// ServiceGrouper decides which output file each capture is saved to.
+type ServiceGrouper struct {
+	config GroupingConfig
+}
+
+func NewServiceGrouper(config GroupingConfig) (*ServiceGrouper, error) {
+	rules := append([]GroupingRule{}, config.Rules...)
+	for i := range rules {
+		if rules[i].PathPattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(rules[i].PathPattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid path_pattern %q: %w", i, rules[i].PathPattern, err)
+		}
+		rules[i].re = re
+	}
+	config.Rules = rules
+
+	if config.Default == "" {
+		config.Default = defaultGroupingConfig.Default
+	}
+	if sanitizeGroupName(config.Default) == reservedGroup {
+		return nil, fmt.Errorf("default group %q is reserved", config.Default)
+	}
+	if config.Filename == "" {
+		config.Filename = defaultGroupingConfig.Filename
+	}
+	if !strings.Contains(config.Filename, "{{group}}") {
+		return nil, fmt.Errorf("filename %q must contain {{group}}", config.Filename)
+	}
+	return &ServiceGrouper{config: config}, nil
+}
+
+// LoadServiceGrouper reads a GroupingConfig file. An empty path gives the
+// built-in grouping.
+func LoadServiceGrouper(configPath string) (*ServiceGrouper, error) {
+	if configPath == "" {
+		return NewServiceGrouper(defaultGroupingConfig)
+	}
+
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var config GroupingConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+	return NewServiceGrouper(config)
+}
+
+func (g *ServiceGrouper) Group(capture CapturedRoute) string {
+	for _, rule := range g.config.Rules {
+		vars := map[string]string{
+			"host":   capture.Host,
+			"method": strings.ToLower(capture.Method),
+		}
+
+		if rule.Host != "" {
+			if ok, _ := path.Match(rule.Host, capture.Host); !ok {
+				continue
+			}
+		}
+		if rule.Operation != "" {
+			if capture.GraphQL == nil {
+				continue
+			}
+			name := capture.GraphQL.OperationName
+			if name == "" {
+				name = "anonymous"
+			}
+			if ok, _ := path.Match(rule.Operation, name); !ok {
+				continue
+			}
+			vars["operation"] = name
+		}
+		if rule.Header != "" {
+			value := headerValue(capture.RequestHeaders, rule.Header)
+			if value == "" {
+				continue
+			}
+			vars["header"] = value
+		}
+		if rule.re != nil {
+			match := rule.re.FindStringSubmatch(capture.Path)
+			if match == nil {
+				continue
+			}
+			for i, group := range match[1:] {
+				vars[fmt.Sprint(i+1)] = group
+			}
+		}
+
+		if group := sanitizeGroupName(expandTemplate(rule.Group, vars)); group != "" && group != reservedGroup {
+			return group
+		}
+	}
+	return g.config.Default
+}
+
+func (g *ServiceGrouper) Filename(group string, now time.Time) string {
+	return expandTemplate(g.config.Filename, map[string]string{
+		"group": group,
+		"date":  now.Format("2006-01-02"),
+	})
+}
+
+var groupingVariable = regexp.MustCompile(`\{\{([^{}]*)\}\}`)
+
+// expandTemplate fills in {{name}} variables; unknown ones expand to "".
+func expandTemplate(template string, vars map[string]string) string {
+	return groupingVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		return vars[variable[2:len(variable)-2]]
+	})
+}
+
+var unsafeGroupChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sanitizeGroupName keeps group names usable as file names.
+func sanitizeGroupName(name string) string {
+	name = unsafeGroupChars.ReplaceAllString(name, "-")
+	return strings.Trim(strings.ReplaceAll(name, "..", "."), "-.")
+}
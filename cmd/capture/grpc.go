@@ -0,0 +1,575 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// GRPCCapture holds the gRPC details of a captured call. Messages are stored
+// as JSON: protojson when the method's descriptor is known, otherwise the
+// raw wire format keyed by field number (see decodeRawMessage).
+type GRPCCapture struct {
+	Service  string            `json:"service"`
+	Method   string            `json:"method"`
+	Status   int               `json:"status"`
+	Message  string            `json:"message,omitempty"`
+	Trailers map[string]string `json:"trailers,omitempty"`
+	// Server-streamed responses; unary responses go in the route's response
+	Stream []interface{} `json:"stream,omitempty"`
+	// "json" (decoded with descriptors) or "raw"
+	Format string `json:"format"`
+}
+
+// reflectionMethods are the server reflection calls whose responses teach
+// the proxy the descriptors of the services they describe.
+var reflectionMethods = map[string]bool{
+	"/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo": true,
+	"/grpc.reflection.v1.ServerReflection/ServerReflectionInfo":      true,
+}
+
+func isGRPC(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return strings.HasPrefix(contentType, "application/grpc") && !strings.HasPrefix(contentType, "application/grpc-web")
+}
+
+// GRPCDescriptors resolves gRPC methods to message types, from descriptor
+// sets given up front (protoc --include_imports --descriptor_set_out) and
+// from server reflection responses seen in captured traffic.
+type GRPCDescriptors struct {
+	mu       sync.Mutex
+	files    map[string]*descriptorpb.FileDescriptorProto
+	registry *protoregistry.Files
+}
+
+func NewGRPCDescriptors() *GRPCDescriptors {
+	return &GRPCDescriptors{
+		files:    make(map[string]*descriptorpb.FileDescriptorProto),
+		registry: new(protoregistry.Files),
+	}
+}
+
+// LoadFile adds the files in a serialized FileDescriptorSet.
+func (d *GRPCDescriptors) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read descriptor set: %w", err)
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse descriptor set %s: %w", path, err)
+	}
+	return d.Add(set.File...)
+}
+
+// Add registers file descriptors. Files whose imports haven't been seen yet
+// are kept and take effect once the rest arrive.
+func (d *GRPCDescriptors) Add(files ...*descriptorpb.FileDescriptorProto) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, file := range files {
+		d.files[file.GetName()] = file
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, file := range d.files {
+		set.File = append(set.File, file)
+	}
+	registry, err := protodesc.NewFiles(set)
+	if err != nil {
+		return err
+	}
+	d.registry = registry
+	return nil
+}
+
+// Method looks up a method by its HTTP/2 path, "/package.Service/Method".
+func (d *GRPCDescriptors) Method(path string) protoreflect.MethodDescriptor {
+	service, method, ok := splitGRPCPath(path)
+	if !ok {
+		return nil
+	}
+
+	d.mu.Lock()
+	registry := d.registry
+	d.mu.Unlock()
+
+	desc, err := registry.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return nil
+	}
+	if sd, ok := desc.(protoreflect.ServiceDescriptor); ok {
+		return sd.Methods().ByName(protoreflect.Name(method))
+	}
+	return nil
+}
+
+// learnReflection registers the file descriptors carried by server
+// reflection responses.
+func (d *GRPCDescriptors) learnReflection(messages [][]byte) {
+	var files []*descriptorpb.FileDescriptorProto
+	for _, message := range messages {
+		// ServerReflectionResponse.file_descriptor_response (4) holds
+		// repeated bytes file_descriptor_proto (1)
+		for _, response := range wireFields(message, 4) {
+			for _, raw := range wireFields(response, 1) {
+				file := &descriptorpb.FileDescriptorProto{}
+				if proto.Unmarshal(raw, file) == nil {
+					files = append(files, file)
+				}
+			}
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+	if err := d.Add(files...); err != nil {
+		log.Printf("⚠️  Descriptors from server reflection not usable yet: %v", err)
+		return
+	}
+	log.Printf("📖 Learned %d proto file(s) from server reflection", len(files))
+}
+
+// wireFields returns the values of length-delimited field num in message.
+func wireFields(message []byte, num protowire.Number) [][]byte {
+	var values [][]byte
+	for len(message) > 0 {
+		n, typ, length := protowire.ConsumeTag(message)
+		if length < 0 {
+			return values
+		}
+		message = message[length:]
+		if typ == protowire.BytesType && n == num {
+			value, length := protowire.ConsumeBytes(message)
+			if length < 0 {
+				return values
+			}
+			values = append(values, value)
+			message = message[length:]
+			continue
+		}
+		length = protowire.ConsumeFieldValue(n, typ, message)
+		if length < 0 {
+			return values
+		}
+		message = message[length:]
+	}
+	return values
+}
+
+func splitGRPCPath(path string) (service, method string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// LoadGRPCDescriptors loads comma-separated descriptor set files used to
+// decode gRPC messages.
+func (cp *CaptureProxy) LoadGRPCDescriptors(paths string) error {
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		if err := cp.descriptors.LoadFile(path); err != nil {
+			return err
+		}
+		log.Printf("Loaded gRPC descriptors from %s", path)
+	}
+	return nil
+}
+
+// parseGRPCFrames splits a gRPC body into messages, decompressing the ones
+// flagged as compressed with encoding (the grpc-encoding header).
+func parseGRPCFrames(data []byte, encoding string) ([][]byte, error) {
+	var messages [][]byte
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return messages, errors.New("truncated gRPC frame header")
+		}
+		compressed := data[0] == 1
+		length := binary.BigEndian.Uint32(data[1:5])
+		if uint32(len(data)-5) < length {
+			return messages, errors.New("truncated gRPC message")
+		}
+		message := data[5 : 5+length]
+		data = data[5+length:]
+
+		if compressed {
+			decoded, err := decodeBody(message, encoding)
+			if err != nil {
+				return messages, fmt.Errorf("failed to decompress gRPC message: %w", err)
+			}
+			message = decoded
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// decodeMessages turns messages into JSON values, using the method's input
+// or output type when known and the raw wire format otherwise.
+func decodeMessages(messages [][]byte, desc protoreflect.MessageDescriptor) ([]interface{}, string) {
+	values := make([]interface{}, 0, len(messages))
+	if desc != nil {
+		for _, message := range messages {
+			value, err := decodeProtoMessage(message, desc)
+			if err != nil {
+				// Mixing formats within a call would make it unreplayable
+				log.Printf("⚠️  Decoding %s as raw: %v", desc.FullName(), err)
+				values = values[:0]
+				break
+			}
+			values = append(values, value)
+		}
+		if len(values) == len(messages) {
+			return values, "json"
+		}
+	}
+	for _, message := range messages {
+		values = append(values, decodeRawMessage(message))
+	}
+	return values, "raw"
+}
+
+func decodeProtoMessage(data []byte, desc protoreflect.MessageDescriptor) (interface{}, error) {
+	message := dynamicpb.NewMessage(desc)
+	if err := proto.Unmarshal(data, message); err != nil {
+		return nil, err
+	}
+	encoded, err := protojson.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	err = json.Unmarshal(encoded, &value)
+	return value, err
+}
+
+// decodeRawMessage decodes a message without its schema, like protoc
+// --decode_raw. Keys are field numbers and repeated fields become arrays.
+// Varints are numbers; printable length-delimited values are strings,
+// otherwise nested messages when they parse. Values the JSON can't carry
+// unambiguously are tagged so they round-trip: {"@bytes": base64},
+// {"@fixed32": n} and {"@fixed64": n}.
+func decodeRawMessage(data []byte) map[string]interface{} {
+	fields, ok := parseRawMessage(data)
+	if !ok {
+		return map[string]interface{}{"@bytes": base64.StdEncoding.EncodeToString(data)}
+	}
+	return fields
+}
+
+func parseRawMessage(data []byte) (map[string]interface{}, bool) {
+	fields := make(map[string]interface{})
+	for len(data) > 0 {
+		num, typ, length := protowire.ConsumeTag(data)
+		if length < 0 {
+			return nil, false
+		}
+		data = data[length:]
+
+		var value interface{}
+		switch typ {
+		case protowire.VarintType:
+			v, length := protowire.ConsumeVarint(data)
+			if length < 0 {
+				return nil, false
+			}
+			value, data = v, data[length:]
+		case protowire.Fixed32Type:
+			v, length := protowire.ConsumeFixed32(data)
+			if length < 0 {
+				return nil, false
+			}
+			value, data = map[string]interface{}{"@fixed32": v}, data[length:]
+		case protowire.Fixed64Type:
+			v, length := protowire.ConsumeFixed64(data)
+			if length < 0 {
+				return nil, false
+			}
+			value, data = map[string]interface{}{"@fixed64": v}, data[length:]
+		case protowire.BytesType:
+			v, length := protowire.ConsumeBytes(data)
+			if length < 0 {
+				return nil, false
+			}
+			value, data = decodeRawBytes(v), data[length:]
+		default:
+			// Groups are deprecated and rare enough to leave undecoded
+			return nil, false
+		}
+
+		key := strconv.Itoa(int(num))
+		switch existing := fields[key].(type) {
+		case nil:
+			fields[key] = value
+		case []interface{}:
+			fields[key] = append(existing, value)
+		default:
+			fields[key] = []interface{}{existing, value}
+		}
+	}
+	return fields, true
+}
+
+func decodeRawBytes(data []byte) interface{} {
+	if isPrintable(data) {
+		return string(data)
+	}
+	if fields, ok := parseRawMessage(data); ok {
+		return fields
+	}
+	return map[string]interface{}{"@bytes": base64.StdEncoding.EncodeToString(data)}
+}
+
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// grpcValue returns a gRPC trailer, falling back to the headers for
+// Trailers-Only responses.
+func grpcValue(resp *http.Response, name string) string {
+	if value := resp.Trailer.Get(name); value != "" {
+		return value
+	}
+	return resp.Header.Get(name)
+}
+
+// lockedBuffer collects the request body as the transport streams it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+type teeBody struct {
+	io.Reader
+	io.Closer
+}
+
+// h2cTransport forwards cleartext gRPC, which needs HTTP/2 with prior
+// knowledge rather than an upgrade.
+func (cp *CaptureProxy) newH2CTransport() *http2.Transport {
+	return &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return cp.dialTunnel(addr)
+		},
+	}
+}
+
+// forwardGRPC relays a gRPC call, streaming in both directions so server
+// streaming and bidirectional calls work, then records it with its messages
+// decoded to JSON.
+func (cp *CaptureProxy) forwardGRPC(w http.ResponseWriter, r *http.Request, targetURL, serviceName string) {
+	startTime := time.Now()
+	parsedURL, _ := url.Parse(targetURL)
+	recordedURL, fullURL := virtualHost(r, parsedURL, targetURL)
+
+	requestBytes := &lockedBuffer{}
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL, teeBody{io.TeeReader(r.Body, requestBytes), r.Body})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	proxyReq.Host = recordedURL.Host
+	for key, values := range r.Header {
+		// gRPC servers insist on "TE: trailers"
+		if isProxyHeader(key) || (isHopByHopHeader(key) && key != "Te") {
+			continue
+		}
+		for _, value := range values {
+			proxyReq.Header.Add(key, value)
+		}
+	}
+
+	ticket, ok := cp.guardHost(w, r, targetURL)
+	if !ok {
+		return
+	}
+
+	var transport http.RoundTripper = cp.transport
+	if parsedURL.Scheme == "http" {
+		transport = cp.h2c
+	}
+	resp, err := transport.RoundTrip(proxyReq)
+	if err != nil {
+		ticket.done(true, err)
+		log.Printf("Error forwarding gRPC call: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		if isHopByHopHeader(key) {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	var responseBytes bytes.Buffer
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			responseBytes.Write(buf[:n])
+			w.Write(buf[:n])
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			w.Header().Add(http.TrailerPrefix+key, value)
+		}
+	}
+
+	service, method, _ := splitGRPCPath(parsedURL.Path)
+	status, _ := strconv.Atoi(grpcValue(resp, "Grpc-Status"))
+	message, _ := url.PathUnescape(grpcValue(resp, "Grpc-Message"))
+	// UNAVAILABLE is gRPC's way of saying the server is down
+	ticket.done(ticket.failed(resp.StatusCode) || status == 14, nil)
+	call := &GRPCCapture{
+		Service: service,
+		Method:  method,
+		Status:  status,
+		Message: message,
+	}
+	for key, values := range resp.Trailer {
+		if len(values) == 0 || key == "Grpc-Status" || key == "Grpc-Message" {
+			continue
+		}
+		if call.Trailers == nil {
+			call.Trailers = make(map[string]string)
+		}
+		call.Trailers[strings.ToLower(key)] = values[0]
+	}
+
+	requestMessages, err := parseGRPCFrames(requestBytes.Bytes(), r.Header.Get("Grpc-Encoding"))
+	if err != nil {
+		log.Printf("⚠️  %s request: %v", parsedURL.Path, err)
+	}
+	responseMessages, err := parseGRPCFrames(responseBytes.Bytes(), resp.Header.Get("Grpc-Encoding"))
+	if err != nil {
+		log.Printf("⚠️  %s response: %v", parsedURL.Path, err)
+	}
+	if reflectionMethods[parsedURL.Path] {
+		cp.descriptors.learnReflection(responseMessages)
+	}
+
+	var inputDesc, outputDesc protoreflect.MessageDescriptor
+	if md := cp.descriptors.Method(parsedURL.Path); md != nil {
+		inputDesc, outputDesc = md.Input(), md.Output()
+	}
+	requests, requestFormat := decodeMessages(requestMessages, inputDesc)
+	responses, responseFormat := decodeMessages(responseMessages, outputDesc)
+	call.Format = responseFormat
+	if len(responseMessages) == 0 {
+		call.Format = requestFormat
+	}
+
+	var requestBody, responseBody interface{}
+	switch len(requests) {
+	case 0:
+	case 1:
+		requestBody = requests[0]
+	default:
+		requestBody = requests
+	}
+	if len(responses) == 1 {
+		responseBody = responses[0]
+	} else if len(responses) > 1 {
+		call.Stream = responses
+	}
+
+	responseTime := time.Since(startTime).Milliseconds()
+	responseHeaders := make(map[string]string)
+	for key, values := range resp.Header {
+		if len(values) > 0 {
+			responseHeaders[key] = values[0]
+		}
+	}
+	requestHeaders := make(map[string]string)
+	for key, values := range r.Header {
+		if len(values) > 0 && !isProxyHeader(key) {
+			requestHeaders[key] = values[0]
+		}
+	}
+
+	cp.record(CapturedRoute{
+		Method:            r.Method,
+		Path:              parsedURL.Path,
+		Status:            resp.StatusCode,
+		Response:          responseBody,
+		Headers:           responseHeaders,
+		Description:       fmt.Sprintf("Captured from %s", serviceName),
+		CapturedAt:        time.Now(),
+		RequestBody:       requestBody,
+		FullURL:           fullURL,
+		ResponseHeaders:   responseHeaders,
+		RequestHeaders:    requestHeaders,
+		ResponseTime:      responseTime,
+		Host:              recordedURL.Host,
+		HeaderList:        headerList(resp.Header, nil),
+		RequestHeaderList: headerList(r.Header, isProxyHeader),
+		Protocol:          resp.Proto,
+		GRPC:              call,
+	})
+
+	log.Printf("✅ Captured gRPC: %s -> status %d, %d message(s) (%dms)", parsedURL.Path, status, len(responses), responseTime)
+}
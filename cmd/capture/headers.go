@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// HeaderField is one header line. Lists of HeaderField keep every value of
+// repeated headers such as Set-Cookie, Vary and Link, which the flat header
+// maps drop.
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// headerList flattens h into name/value pairs. net/http parses both the
+// requests it serves and the responses http.Transport reads into maps, so the
+// order headers arrived in is gone before the proxy sees them; names are
+// sorted to keep captures stable, and the values of a repeated header keep
+// their original order. Recovering the wire order would mean parsing HTTP/1
+// and HPACK ourselves, which the capture proxy does not do.
+func headerList(h http.Header, skip func(name string) bool) []HeaderField {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		if skip == nil || !skip(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var fields []HeaderField
+	for _, name := range names {
+		for _, value := range h[name] {
+			fields = append(fields, HeaderField{Name: name, Value: value})
+		}
+	}
+	return fields
+}
+
+func isProxyHeader(name string) bool {
+	return strings.HasPrefix(name, "Proxy-")
+}
+
+// isHopByHopHeader reports headers that describe a single connection and
+// must not be relayed, which HTTP/2 in particular rejects.
+func isHopByHopHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Connection", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade", "Te", "Trailer":
+		return true
+	}
+	return false
+}
@@ -1,13 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -33,6 +34,17 @@ type CapturedRoute struct {
 	QueryParams     map[string]string      `json:"query_params,omitempty"`
 	ResponseTime    int64                  `json:"response_time_ms,omitempty"`
 	Host            string                 `json:"host,omitempty"`
+	// Lossless headers; header_list is replayed by the mock server
+	HeaderList        []HeaderField `json:"header_list,omitempty"`
+	RequestHeaderList []HeaderField `json:"request_header_list,omitempty"`
+	// Bodies are stored decoded; this is the Content-Encoding they arrived with
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// Upstream response protocol, e.g. "HTTP/1.1" or "HTTP/2.0"
+	Protocol string `json:"protocol,omitempty"`
+	// gRPC status, trailers and streamed messages for gRPC calls
+	GRPC *GRPCCapture `json:"grpc,omitempty"`
+	// Operation of a GraphQL request; the mock matches on it
+	GraphQL *GraphQLOperation `json:"graphql,omitempty"`
 }
 
 type CaptureProxy struct {
@@ -41,15 +53,39 @@ type CaptureProxy struct {
 	mu          sync.Mutex
 	outputDir   string
 	client      *http.Client
+	transport   *http.Transport
+	h2c         http.RoundTripper
+	descriptors *GRPCDescriptors
+	upstream    *UpstreamProxy
+	guard       *UpstreamGuard
+	mitm        *MITM
+	normalizer  *PathNormalizer
+	grouper     *ServiceGrouper
+	redactor    *Redactor
+	anonymizer  *Anonymizer
+	filter      *CaptureFilter
+	filtered    int
+	stream      *captureStream
 }
 
 func NewCaptureProxy(outputDir string) *CaptureProxy {
 	// Create HTTP client that handles HTTPS
 	tr := &http.Transport{
 		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		// A custom TLS config turns HTTP/2 off unless asked for
+		ForceAttemptHTTP2: true,
 	}
 	
-	return &CaptureProxy{
+	normalizer, _ := NewPathNormalizer(NormalizeConfig{})
+	grouper, _ := NewServiceGrouper(defaultGroupingConfig)
+	redactor, err := LoadRedactor("")
+	if err != nil {
+		log.Fatalf("Failed to initialise redaction: %v", err)
+	}
+	filter, _ := NewCaptureFilter(FilterConfig{})
+	guard, _ := NewUpstreamGuard(ProtectionConfig{})
+	
+	cp := &CaptureProxy{
 		targetHosts: make(map[string]*url.URL),
 		captures:    make([]CapturedRoute, 0),
 		outputDir:   outputDir,
@@ -57,7 +93,84 @@ func NewCaptureProxy(outputDir string) *CaptureProxy {
 			Transport: tr,
 			Timeout:   30 * time.Second,
 		},
+		transport:   tr,
+		normalizer:  normalizer,
+		grouper:     grouper,
+		redactor:    redactor,
+		filter:      filter,
+		stream:      newCaptureStream(),
+		descriptors: NewGRPCDescriptors(),
+		guard:       guard,
+	}
+	cp.h2c = cp.newH2CTransport()
+	return cp
+}
+
+// LoadNormalizeRules replaces the built-in path normalization rules with the
+// ones in configPath.
+func (cp *CaptureProxy) LoadNormalizeRules(configPath string) error {
+	normalizer, err := LoadPathNormalizer(configPath)
+	if err != nil {
+		return err
+	}
+	cp.normalizer = normalizer
+	log.Printf("Loaded path normalization rules from %s", configPath)
+	return nil
+}
+
+// LoadGroupingRules replaces the built-in service grouping used by
+// SaveCaptures with the one in configPath.
+func (cp *CaptureProxy) LoadGroupingRules(configPath string) error {
+	grouper, err := LoadServiceGrouper(configPath)
+	if err != nil {
+		return err
+	}
+	cp.grouper = grouper
+	log.Printf("Loaded service grouping rules from %s", configPath)
+	return nil
+}
+
+// LoadRedactionRules adds the body rules and header/field names in configPath
+// to the secret redaction applied before captures are stored.
+func (cp *CaptureProxy) LoadRedactionRules(configPath string) error {
+	redactor, err := LoadRedactor(configPath)
+	if err != nil {
+		return err
+	}
+	cp.redactor = redactor
+	log.Printf("Loaded redaction rules from %s", configPath)
+	return nil
+}
+
+// EnableAnonymization makes SaveCaptures replace personal data with
+// consistent fake values, using the config in configPath if set.
+func (cp *CaptureProxy) EnableAnonymization(configPath string) error {
+	anonymizer, err := LoadAnonymizer(configPath)
+	if err != nil {
+		return err
 	}
+	cp.anonymizer = anonymizer
+	log.Println("Anonymization of saved captures enabled")
+	return nil
+}
+
+// record runs a capture through the filters and redaction pipeline and
+// stores it.
+func (cp *CaptureProxy) record(captured CapturedRoute) {
+	if !cp.Filter().Allow(captured) {
+		cp.mu.Lock()
+		cp.filtered++
+		cp.mu.Unlock()
+		return
+	}
+	captured = cp.redactor.Redact(captured)
+
+	// Publishing under the lock keeps stream sequence IDs in step with
+	// /capture/live snapshots
+	cp.mu.Lock()
+	cp.captures = append(cp.captures, captured)
+	cp.stream.Publish(captured)
+	cp.mu.Unlock()
 }
 
 func (cp *CaptureProxy) AddTarget(name string, targetURL string) error {
@@ -91,8 +204,8 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			targetURL = r.URL.String()
 			serviceName = r.URL.Host
 		} else {
-			// Relative URL - shouldn't happen in proxy mode
-			http.Error(w, "Invalid proxy request", http.StatusBadRequest)
+			// Relative URL - redirected traffic belongs on TRANSPARENT_PORT
+			http.Error(w, "Invalid proxy request (send iptables-redirected traffic to TRANSPARENT_PORT)", http.StatusBadRequest)
 			return
 		}
 		
@@ -103,11 +216,36 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	cp.forward(w, r, targetURL, serviceName)
+}
+
+// virtualHost returns the URL to record a request under and its string
+// form. It is target unless the request's Host header names another host,
+// as for requests pinned to a SOCKS destination, in which case the capture
+// uses the host the client asked for.
+func virtualHost(r *http.Request, target *url.URL, targetURL string) (*url.URL, string) {
+	if r.Host == "" || r.Host == target.Host {
+		return target, targetURL
+	}
+	recorded := *target
+	recorded.Host = r.Host
+	return &recorded, recorded.String()
+}
+
+// forward sends r to targetURL, records the exchange and relays the
+// response back to the client.
+func (cp *CaptureProxy) forward(w http.ResponseWriter, r *http.Request, targetURL, serviceName string) {
+	if isGRPC(r) {
+		cp.forwardGRPC(w, r, targetURL, serviceName)
+		return
+	}
+	
 	// Track request start time
 	startTime := time.Now()
 	
 	// Parse the URL to extract query parameters
 	parsedURL, _ := url.Parse(targetURL)
+	recordedURL, fullURL := virtualHost(r, parsedURL, targetURL)
 	queryParams := make(map[string]string)
 	for key, values := range parsedURL.Query() {
 		if len(values) > 0 {
@@ -122,6 +260,7 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			requestHeaders[key] = values[0]
 		}
 	}
+	requestHeaderList := headerList(r.Header, isProxyHeader)
 	
 	// Create new request to forward
 	proxyReq, err := http.NewRequest(r.Method, targetURL, r.Body)
@@ -129,6 +268,7 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
 	}
+	proxyReq.Host = recordedURL.Host
 	
 	// Copy headers from original request
 	for key, values := range r.Header {
@@ -151,6 +291,14 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 			proxyReq.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 			
+			if encoding := strings.Join(r.Header.Values("Content-Encoding"), ","); encoding != "" {
+				if decoded, err := decodeBody(bodyBytes, encoding); err == nil {
+					bodyBytes = decoded
+				} else {
+					log.Printf("⚠️  Storing request body still encoded: %v", err)
+				}
+			}
+			
 			// Try to parse as JSON
 			var reqBody interface{}
 			if json.Unmarshal(bodyBytes, &reqBody) == nil {
@@ -162,9 +310,21 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 	
+	// Refuse straight away while the upstream is failing or saturated
+	ticket, ok := cp.guardHost(w, r, targetURL)
+	if !ok {
+		return
+	}
+	if ticket.timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), ticket.timeout)
+		defer cancel()
+		proxyReq = proxyReq.WithContext(ctx)
+	}
+	
 	// Make the request
 	resp, err := cp.client.Do(proxyReq)
 	if err != nil {
+		ticket.done(true, err)
 		log.Printf("Error forwarding request: %v", err)
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
@@ -173,6 +333,7 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	
 	// Read response body
 	respBody, err := io.ReadAll(resp.Body)
+	ticket.done(err != nil || ticket.failed(resp.StatusCode), err)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadGateway)
 		return
@@ -181,22 +342,39 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Calculate response time
 	responseTime := time.Since(startTime).Milliseconds()
 	
+	// Decode compressed responses for storage; the client still receives
+	// the original bytes
+	storedBody, storedHeader := respBody, resp.Header
+	contentEncoding := strings.Join(resp.Header.Values("Content-Encoding"), ",")
+	if contentEncoding != "" {
+		if decoded, err := decodeBody(respBody, contentEncoding); err == nil {
+			storedBody, storedHeader = decoded, decodedHeaders(resp.Header)
+		} else {
+			log.Printf("⚠️  Storing response body still encoded: %v", err)
+			contentEncoding = ""
+		}
+	} else if resp.Uncompressed {
+		// The transport asked for gzip itself and has already decoded it
+		contentEncoding = "gzip"
+	}
+	
 	// Capture response headers
 	responseHeaders := make(map[string]string)
-	for key, values := range resp.Header {
+	for key, values := range storedHeader {
 		if len(values) > 0 {
 			responseHeaders[key] = values[0]
 		}
 	}
+	responseHeaderList := headerList(storedHeader, nil)
 	
 	// Try to parse response body
 	var responseBody interface{}
 	var jsonBody interface{}
-	if err := json.Unmarshal(respBody, &jsonBody); err == nil {
+	if err := json.Unmarshal(storedBody, &jsonBody); err == nil {
 		responseBody = jsonBody
-	} else if len(respBody) > 0 {
+	} else if len(storedBody) > 0 {
 		// If not JSON, store as string (truncate if too long)
-		bodyStr := string(respBody)
+		bodyStr := string(storedBody)
 		if len(bodyStr) > 10000 {
 			bodyStr = bodyStr[:10000] + "... (truncated)"
 		}
@@ -206,7 +384,7 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Always capture the request/response, even if not JSON
 	captured := CapturedRoute{
 		Method:      r.Method,
-		Path:        normalizePathForTemplate(parsedURL.Path),
+		Path:        cp.normalizer.Normalize(recordedURL.Host, parsedURL.Path),
 		Status:      resp.StatusCode,
 		Response:    responseBody,
 		Headers:     responseHeaders, // For backward compatibility
@@ -214,22 +392,32 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		CapturedAt:  time.Now(),
 		RequestBody: requestBody,
 		// Extended details
-		FullURL:         targetURL,
-		ResponseHeaders: responseHeaders,
-		RequestHeaders:  requestHeaders,
-		QueryParams:     queryParams,
-		ResponseTime:    responseTime,
-		Host:            parsedURL.Host,
+		FullURL:           fullURL,
+		ResponseHeaders:   responseHeaders,
+		RequestHeaders:    requestHeaders,
+		QueryParams:       queryParams,
+		ResponseTime:      responseTime,
+		Host:              recordedURL.Host,
+		HeaderList:        responseHeaderList,
+		RequestHeaderList: requestHeaderList,
+		ContentEncoding:   contentEncoding,
+		Protocol:          resp.Proto,
 	}
 	
-	cp.mu.Lock()
-	cp.captures = append(cp.captures, captured)
-	cp.mu.Unlock()
+	if operation := detectGraphQL(r.Method, parsedURL.Query(), requestBody); operation != nil {
+		captured.GraphQL = operation
+		captured.Description = graphQLDescription(operation, serviceName)
+	}
+	
+	cp.record(captured)
 	
 	log.Printf("✅ Captured: %s %s -> %d (%dms)", r.Method, parsedURL.Path, resp.StatusCode, responseTime)
 	
 	// Copy response headers
 	for key, values := range resp.Header {
+		if isHopByHopHeader(key) {
+			continue
+		}
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
@@ -242,31 +430,6 @@ func (cp *CaptureProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(respBody)
 }
 
-func normalizePathForTemplate(path string) string {
-	parts := strings.Split(path, "/")
-	for i, part := range parts {
-		if isNumeric(part) || isUUID(part) || (len(part) > 10 && !strings.Contains(part, "-")) {
-			parts[i] = "{id}"
-		} else if strings.HasPrefix(part, "CUST-") || strings.HasPrefix(part, "ACC-") {
-			parts[i] = "{id}"
-		}
-	}
-	return strings.Join(parts, "/")
-}
-
-func isNumeric(s string) bool {
-	for _, c := range s {
-		if c < '0' || c > '9' {
-			return false
-		}
-	}
-	return len(s) > 0
-}
-
-func isUUID(s string) bool {
-	return len(s) == 36 && strings.Count(s, "-") == 4
-}
-
 func (cp *CaptureProxy) SaveCaptures() error {
 	cp.mu.Lock()
 	defer cp.mu.Unlock()
@@ -275,16 +438,25 @@ func (cp *CaptureProxy) SaveCaptures() error {
 		return fmt.Errorf("no captures to save")
 	}
 
+	captures := cp.captures
+	if cp.anonymizer != nil {
+		captures = cp.anonymizer.AnonymizeAll(captures)
+	}
+
 	groupedByService := make(map[string][]CapturedRoute)
-	for _, capture := range cp.captures {
-		service := extractServiceName(capture.Path)
+	for _, capture := range captures {
+		service := cp.grouper.Group(capture)
 		groupedByService[service] = append(groupedByService[service], capture)
 	}
 
 	os.MkdirAll(cp.outputDir, 0755)
 
+	now := time.Now()
 	for service, routes := range groupedByService {
-		filename := filepath.Join(cp.outputDir, fmt.Sprintf("%s-captured.json", service))
+		filename := filepath.Join(cp.outputDir, cp.grouper.Filename(service, now))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return err
+		}
 		
 		output := map[string][]CapturedRoute{
 			"routes": routes,
@@ -304,7 +476,7 @@ func (cp *CaptureProxy) SaveCaptures() error {
 
 	combinedFile := filepath.Join(cp.outputDir, "all-captured.json")
 	allRoutes := map[string][]CapturedRoute{
-		"routes": cp.captures,
+		"routes": captures,
 	}
 
 	data, err := json.MarshalIndent(allRoutes, "", "  ")
@@ -323,33 +495,38 @@ func (cp *CaptureProxy) SaveCaptures() error {
 	return nil
 }
 
-func extractServiceName(path string) string {
-	if strings.Contains(path, "/accounts") {
-		return "accounts"
-	} else if strings.Contains(path, "/customers") {
-		return "customers"
-	} else if strings.Contains(path, "/cards") || strings.Contains(path, "/wallet") {
-		return "cards"
-	} else if strings.Contains(path, "/ledger") {
-		return "ledger"
-	} else if strings.Contains(path, "/statements") {
-		return "statements"
-	} else if strings.Contains(path, "/authorizations") {
-		return "authorizations"
-	} else if strings.Contains(path, "/users") {
-		return "users"
-	} else if strings.Contains(path, "/posts") {
-		return "posts"
-	}
-	return "misc"
-}
-
 // handleConnect handles CONNECT method for HTTPS tunneling
 func (cp *CaptureProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 	log.Printf("🔒 CONNECT tunnel requested for: %s", r.Host)
 	
+	if cp.mitm != nil {
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "Hijacking not supported", http.StatusInternalServerError)
+			return
+		}
+		clientConn, _, err := hijacker.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer clientConn.Close()
+		
+		clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+		cp.interceptTLS(clientConn, r.Host, false)
+		return
+	}
+	
 	// Establish connection to the target
-	targetConn, err := net.Dial("tcp", r.Host)
+	ticket, err := cp.guard.acquire(r.Host)
+	if err != nil {
+		rejectUnavailable(w, r, err.(*UnavailableError))
+		return
+	}
+	// The tunnel holds its concurrency slot until it closes
+	defer ticket.release()
+	targetConn, err := cp.dialTunnel(r.Host)
+	ticket.result(err != nil, err)
 	if err != nil {
 		log.Printf("Error connecting to %s: %v", r.Host, err)
 		http.Error(w, err.Error(), http.StatusBadGateway)
@@ -374,40 +551,71 @@ func (cp *CaptureProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
 	// Send 200 Connection Established response
 	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
 	
+	// Cleartext HTTP through the tunnel (e.g. plaintext gRPC) is captured;
+	// each request then takes its own slot, so the tunnel gives its back
+	client := &bufferedConn{Conn: clientConn, reader: bufio.NewReader(clientConn)}
+	if serve := cp.plaintextServer(client, r.Host, false); serve != nil {
+		ticket.release()
+		serve()
+		return
+	}
+	
+	cp.recordTunnel(r.Host, r.Header)
+	
+	log.Printf("✅ HTTPS tunnel established to %s (content not captured)", r.Host)
+	
+	// Start bidirectional copy
+	relay(client, targetConn)
+}
+
+// recordTunnel logs an opaque tunnel to host as a CONNECT capture.
+func (cp *CaptureProxy) recordTunnel(host string, header http.Header) {
 	// Capture request headers
 	requestHeaders := make(map[string]string)
-	for key, values := range r.Header {
+	for key, values := range header {
 		if len(values) > 0 && !strings.HasPrefix(key, "Proxy-") {
 			requestHeaders[key] = values[0]
 		}
 	}
 	
 	// Log that we're tunneling HTTPS (can't capture content without MITM)
-	cp.mu.Lock()
-	cp.captures = append(cp.captures, CapturedRoute{
+	cp.record(CapturedRoute{
 		Method:      "CONNECT",
-		Path:        r.Host,
+		Path:        host,
 		Status:      200,
 		Response:    map[string]string{"note": "HTTPS tunnel established", "info": "Content not captured (encrypted). Use MITM proxy for full capture."},
 		Headers:     map[string]string{"Connection": "Established"},
-		Description: fmt.Sprintf("HTTPS tunnel to %s", r.Host),
+		Description: fmt.Sprintf("HTTPS tunnel to %s", host),
 		CapturedAt:  time.Now(),
 		// Extended details
-		FullURL:         "https://" + r.Host,
-		ResponseHeaders: map[string]string{"Connection": "Established"},
-		RequestHeaders:  requestHeaders,
-		Host:            r.Host,
+		FullURL:           "https://" + host,
+		ResponseHeaders:   map[string]string{"Connection": "Established"},
+		RequestHeaders:    requestHeaders,
+		Host:              host,
+		RequestHeaderList: headerList(header, isProxyHeader),
 	})
-	cp.mu.Unlock()
-	
-	log.Printf("✅ HTTPS tunnel established to %s (content not captured)", r.Host)
-	
-	// Start bidirectional copy
-	go io.Copy(targetConn, clientConn)
-	io.Copy(clientConn, targetConn)
+}
+
+// commands are the offline tools built into the capture binary, run as
+// "capture-proxy <command> [flags]".
+var commands = map[string]func(args []string) error{
+	"openapi":   runOpenAPI,
+	"resources": runResources,
+	"normalize": runNormalize,
+	"anonymize": runAnonymize,
+	"mitm-ca":   runMITMCA,
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	port := os.Getenv("CAPTURE_PORT")
 	if port == "" {
 		port = "8091"
@@ -422,6 +630,53 @@ func main() {
 
 	proxy := NewCaptureProxy(outputDir)
 
+	if rulesPath := os.Getenv("NORMALIZE_RULES"); rulesPath != "" {
+		if err := proxy.LoadNormalizeRules(rulesPath); err != nil {
+			log.Fatalf("Failed to load normalization rules: %v", err)
+		}
+	}
+	if redactionPath := os.Getenv("REDACTION_CONFIG"); redactionPath != "" {
+		if err := proxy.LoadRedactionRules(redactionPath); err != nil {
+			log.Fatalf("Failed to load redaction rules: %v", err)
+		}
+	}
+	if anonymizePath := os.Getenv("ANONYMIZE_CONFIG"); anonymizePath != "" || os.Getenv("ANONYMIZE") == "true" {
+		if err := proxy.EnableAnonymization(anonymizePath); err != nil {
+			log.Fatalf("Failed to load anonymization config: %v", err)
+		}
+	}
+	if groupingPath := os.Getenv("GROUPING_CONFIG"); groupingPath != "" {
+		if err := proxy.LoadGroupingRules(groupingPath); err != nil {
+			log.Fatalf("Failed to load grouping rules: %v", err)
+		}
+	}
+	if caCert, caKey := os.Getenv("MITM_CA_CERT"), os.Getenv("MITM_CA_KEY"); caCert != "" && caKey != "" {
+		if err := proxy.EnableMITM(caCert, caKey); err != nil {
+			log.Fatalf("Failed to enable MITM: %v", err)
+		}
+	}
+	if upstreamProxy := os.Getenv("UPSTREAM_PROXY"); upstreamProxy != "" {
+		if err := proxy.SetUpstreamProxy(upstreamProxy, os.Getenv("UPSTREAM_NO_PROXY"), os.Getenv("UPSTREAM_PROXY_INSECURE") == "true"); err != nil {
+			log.Fatalf("Failed to configure upstream proxy: %v", err)
+		}
+	}
+	if descriptorPaths := os.Getenv("GRPC_DESCRIPTORS"); descriptorPaths != "" {
+		if err := proxy.LoadGRPCDescriptors(descriptorPaths); err != nil {
+			log.Fatalf("Failed to load gRPC descriptors: %v", err)
+		}
+	}
+	if protectionPath := os.Getenv("UPSTREAM_PROTECTION_CONFIG"); protectionPath != "" {
+		if err := proxy.LoadUpstreamProtection(protectionPath); err != nil {
+			log.Fatalf("Failed to load upstream protection settings: %v", err)
+		}
+	}
+	if filterPath := os.Getenv("CAPTURE_FILTERS"); filterPath != "" {
+		if err := proxy.LoadFilterRules(filterPath); err != nil {
+			log.Fatalf("Failed to load capture filters: %v", err)
+		}
+		go proxy.WatchFilterRules(filterPath)
+	}
+
 	if transparentMode {
 		log.Println("🔍 TRANSPARENT MODE ENABLED")
 		log.Println("The proxy will automatically detect and forward to actual destinations")
@@ -466,11 +721,14 @@ func main() {
 	mux.HandleFunc("/capture/status", func(w http.ResponseWriter, r *http.Request) {
 		proxy.mu.Lock()
 		count := len(proxy.captures)
+		filtered := proxy.filtered
 		proxy.mu.Unlock()
 		
 		response := map[string]interface{}{
 			"captured_routes": count,
+			"filtered_out":    filtered,
 			"output_dir":      outputDir,
+			"upstreams":       proxy.guard.Status(),
 		}
 		json.NewEncoder(w).Encode(response)
 	})
@@ -479,6 +737,7 @@ func main() {
 		proxy.mu.Lock()
 		captures := make([]CapturedRoute, len(proxy.captures))
 		copy(captures, proxy.captures)
+		seq := proxy.stream.Seq()
 		proxy.mu.Unlock()
 		
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -486,9 +745,84 @@ func main() {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"routes": captures,
 			"count":  len(captures),
+			"seq":    seq,
 		})
 	})
 	
+	// Push new captures as they happen; resume with ?since=<seq>
+	mux.HandleFunc("/capture/stream", proxy.handleStream)
+	
+	mux.HandleFunc("/capture/query", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		
+		query, err := ParseCaptureQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proxy.Query(query))
+	})
+	
+	mux.HandleFunc("/capture/openapi", func(w http.ResponseWriter, r *http.Request) {
+		proxy.mu.Lock()
+		captures := make([]CapturedRoute, len(proxy.captures))
+		copy(captures, proxy.captures)
+		proxy.mu.Unlock()
+
+		title := r.URL.Query().Get("title")
+		if title == "" {
+			title = "Captured API"
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(GenerateOpenAPI(captures, title))
+	})
+	
+	mux.HandleFunc("/capture/resources", func(w http.ResponseWriter, r *http.Request) {
+		proxy.mu.Lock()
+		captures := make([]CapturedRoute, len(proxy.captures))
+		copy(captures, proxy.captures)
+		proxy.mu.Unlock()
+
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resourceRoutes(captures, r.URL.Query().Get("all") == "true"))
+	})
+	
+	mux.HandleFunc("/capture/filters", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var config FilterConfig
+			if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+				http.Error(w, "Invalid filter config: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			filter, err := NewCaptureFilter(config)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			proxy.SetFilter(filter)
+			log.Printf("Capture filters updated: %d include, %d exclude rules", len(config.Include), len(config.Exclude))
+		case http.MethodDelete:
+			filter, _ := NewCaptureFilter(FilterConfig{})
+			proxy.SetFilter(filter)
+			log.Println("Capture filters cleared")
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(proxy.Filter().Config())
+	})
+	
 	mux.HandleFunc("/capture/clear", func(w http.ResponseWriter, r *http.Request) {
 		proxy.mu.Lock()
 		proxy.captures = make([]CapturedRoute, 0)
@@ -510,11 +844,33 @@ func main() {
 		}
 	})
 
+	if socksPort := os.Getenv("SOCKS_PORT"); socksPort != "" {
+		socks := NewSOCKSServer(proxy, os.Getenv("SOCKS_USERNAME"), os.Getenv("SOCKS_PASSWORD"))
+		go func() {
+			log.Printf("SOCKS5 listener starting on port %s", socksPort)
+			if err := socks.ListenAndServe(":" + socksPort); err != nil {
+				log.Fatalf("SOCKS5 listener failed: %v", err)
+			}
+		}()
+	}
+
+	if transparentPort := os.Getenv("TRANSPARENT_PORT"); transparentPort != "" {
+		tproxy := os.Getenv("TRANSPARENT_TPROXY") == "true"
+		listener := NewTransparentListener(proxy, tproxy)
+		go func() {
+			log.Printf("Transparent listener starting on port %s (tproxy=%v)", transparentPort, tproxy)
+			if err := listener.ListenAndServe(":" + transparentPort); err != nil {
+				log.Fatalf("Transparent listener failed: %v", err)
+			}
+		}()
+	}
+
 	log.Printf("Capture Proxy starting on port %s", port)
 	log.Printf("Output directory: %s", outputDir)
 	log.Println("Configure your app to use this proxy by setting API URLs to http://localhost:" + port)
 	log.Println("Save captures: curl http://localhost:" + port + "/capture/save")
 	log.Println("Check status: curl http://localhost:" + port + "/capture/status")
+	log.Println("Live stream: curl -N http://localhost:" + port + "/capture/stream")
 	
 	if err := http.ListenAndServe(":"+port, handler); err != nil {
 		log.Fatal(err)
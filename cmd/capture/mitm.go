@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// MITM terminates TLS for tunnelled connections with per-host certificates
+// signed by a local CA, so HTTPS traffic (HTTP/1.1 and HTTP/2) is captured
+// like plain HTTP. Clients must trust the CA.
+type MITM struct {
+	ca    *x509.Certificate
+	caKey crypto.Signer
+	key   *ecdsa.PrivateKey
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+}
+
+// LoadMITM reads a PEM CA certificate and key, as written by the mitm-ca
+// command.
+func LoadMITM(certFile, keyFile string) (*MITM, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load MITM CA: %w", err)
+	}
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	if !ca.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported CA key type %T", pair.PrivateKey)
+	}
+
+	// One key serves every leaf certificate; only the signatures differ
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &MITM{ca: ca, caKey: signer, key: key, certs: make(map[string]*tls.Certificate)}, nil
+}
+
+// certificate returns a cached leaf certificate for host, issuing one on
+// first use.
+func (m *MITM) certificate(host string) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if cert, ok := m.certs[host]; ok {
+		return cert, nil
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	notAfter := time.Now().AddDate(1, 0, 0)
+	if notAfter.After(m.ca.NotAfter) {
+		notAfter = m.ca.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, m.ca, &m.key.PublicKey, m.caKey)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{Certificate: [][]byte{der, m.ca.Raw}, PrivateKey: m.key}
+	m.certs[host] = cert
+	return cert, nil
+}
+
+// EnableMITM makes CONNECT, SOCKS and transparent TLS connections be
+// decrypted and captured using the CA in certFile/keyFile.
+func (cp *CaptureProxy) EnableMITM(certFile, keyFile string) error {
+	mitm, err := LoadMITM(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cp.mitm = mitm
+	log.Printf("🔓 MITM enabled for HTTPS tunnels (CA: %s)", mitm.ca.Subject.CommonName)
+	return nil
+}
+
+// interceptTLS terminates the client's TLS connection to host and serves
+// the decrypted requests through the capture pipeline, over HTTP/2 when the
+// client negotiates it. Connections that aren't TLS are captured if they
+// carry plain HTTP and tunnelled otherwise. pin is as for servePlaintext.
+func (cp *CaptureProxy) interceptTLS(conn net.Conn, host string, pin bool) {
+	reader := bufio.NewReader(conn)
+	client := &bufferedConn{Conn: conn, reader: reader}
+	if !sniffTLS(conn, reader) {
+		if !cp.servePlaintext(client, host, pin) {
+			cp.tunnelConn(client, host, host)
+		}
+		return
+	}
+
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+
+	tlsConn := tls.Server(client, &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = hostname
+			}
+			return cp.mitm.certificate(name)
+		},
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		log.Printf("⚠️  TLS handshake with client for %s failed (does it trust the MITM CA?): %v", host, err)
+		return
+	}
+
+	handler := cp.proxyHandler("https", host, pin)
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		(&http2.Server{}).ServeConn(tlsConn, &http2.ServeConnOpts{Handler: handler})
+		return
+	}
+	serveConn(tlsConn, handler)
+}
+
+// runMITMCA implements the "mitm-ca" command, which writes a new CA
+// certificate and key for MITM_CA_CERT/MITM_CA_KEY.
+func runMITMCA(args []string) error {
+	fs := flag.NewFlagSet("mitm-ca", flag.ExitOnError)
+	out := fs.String("out", ".", "directory to write mitm-ca.pem and mitm-ca-key.pem to")
+	name := fs.String("name", "Capture Proxy MITM CA", "CA common name")
+	fs.Parse(args)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: *name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(5, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	certPath := filepath.Join(*out, "mitm-ca.pem")
+	keyPath := filepath.Join(*out, "mitm-ca-key.pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+
+	fmt.Printf("Wrote %s and %s\n", certPath, keyPath)
+	fmt.Printf("Start the proxy with MITM_CA_CERT=%s MITM_CA_KEY=%s and add %s to the client's trust store\n", certPath, keyPath, certPath)
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// NormalizeRule replaces a path segment with a named placeholder. Rules are
+// tried in order and the first match wins. Host (a glob) and PathPrefix limit
+// where a rule applies; After only matches segments that directly follow
+// that literal segment.
+type NormalizeRule struct {
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+	After      string `json:"after,omitempty"`
+	Pattern    string `json:"pattern"`
+	MinLength  int    `json:"min_length,omitempty"`
+	Param      string `json:"param"`
+
+	re *regexp.Regexp
+}
+
+// NormalizeConfig is the file format read from NORMALIZE_RULES. The built-in
+// rules run after the configured ones unless DisableDefaults is set.
+type NormalizeConfig struct {
+	Rules           []NormalizeRule `json:"rules"`
+	Literals        []string        `json:"literals,omitempty"`
+	DisableDefaults bool            `json:"disable_defaults,omitempty"`
+}
+
+var defaultNormalizeRules = []NormalizeRule{
+	{Pattern: `^[0-9]+$`, Param: "id"},
+	{Pattern: `^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`, Param: "id"},
+	{Pattern: `^(CUST|ACC)-`, Param: "id"},
+	// Long opaque tokens; requiring a digit keeps words like "authorizations" literal
+	{Pattern: `^[A-Za-z0-9_]*[0-9][A-Za-z0-9_]*$`, MinLength: 11, Param: "id"},
+}
+
+// PathNormalizer turns concrete request paths into route templates.
+type PathNormalizer struct {
+	rules    []NormalizeRule
+	literals map[string]bool
+}
+
+func NewPathNormalizer(config NormalizeConfig) (*PathNormalizer, error) {
+	rules := append([]NormalizeRule{}, config.Rules...)
+	if !config.DisableDefaults {
+		rules = append(rules, defaultNormalizeRules...)
+	}
+
+	for i := range rules {
+		re, err := regexp.Compile(rules[i].Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: invalid pattern %q: %w", i, rules[i].Pattern, err)
+		}
+		if rules[i].Param == "" {
+			rules[i].Param = "id"
+		}
+		rules[i].re = re
+	}
+
+	literals := make(map[string]bool)
+	for _, literal := range config.Literals {
+		literals[literal] = true
+	}
+
+	return &PathNormalizer{rules: rules, literals: literals}, nil
+}
+
+// LoadPathNormalizer reads a NormalizeConfig file. An empty path gives the
+// built-in rules.
+func LoadPathNormalizer(configPath string) (*PathNormalizer, error) {
+	var config NormalizeConfig
+	if configPath != "" {
+		data, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+		}
+	}
+	return NewPathNormalizer(config)
+}
+
+func (n *PathNormalizer) Normalize(host, requestPath string) string {
+	parts := strings.Split(requestPath, "/")
+	for i, part := range parts {
+		if part == "" || n.literals[part] {
+			continue
+		}
+		for _, rule := range n.rules {
+			if rule.matches(host, requestPath, parts, i) {
+				parts[i] = "{" + rule.Param + "}"
+				break
+			}
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func (r *NormalizeRule) matches(host, requestPath string, parts []string, i int) bool {
+	if r.Host != "" {
+		if ok, _ := path.Match(r.Host, host); !ok {
+			return false
+		}
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(requestPath, r.PathPrefix) {
+		return false
+	}
+	if r.After != "" && (i == 0 || parts[i-1] != r.After) {
+		return false
+	}
+	if len(parts[i]) < r.MinLength {
+		return false
+	}
+	return r.re.MatchString(parts[i])
+}
+
+// runNormalize implements the "normalize" command: a dry run that shows how
+// the configured rules would template the paths in existing capture files.
+func runNormalize(args []string) error {
+	fs := flag.NewFlagSet("normalize", flag.ExitOnError)
+	rulesPath := fs.String("rules", os.Getenv("NORMALIZE_RULES"), "normalization rules file (default built-in rules)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: normalize [-rules rules.json] captured/all-captured.json ...")
+	}
+
+	normalizer, err := LoadPathNormalizer(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	type preview struct{ method, host, original, current, normalized string }
+	seen := make(map[string]bool)
+	var previews []preview
+	templates := make(map[string]int)
+
+	for _, file := range fs.Args() {
+		captures, err := loadCaptureFile(file)
+		if err != nil {
+			return err
+		}
+		for _, capture := range captures {
+			if capture.Method == "CONNECT" {
+				continue
+			}
+			host, original := capture.Host, capture.Path
+			if u, err := url.Parse(capture.FullURL); err == nil && u.Path != "" {
+				host, original = u.Host, u.Path
+			}
+
+			normalized := normalizer.Normalize(host, original)
+			templates[capture.Method+" "+normalized]++
+
+			key := capture.Method + " " + host + original
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			previews = append(previews, preview{capture.Method, host, original, capture.Path, normalized})
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tHOST\tPATH\tNORMALIZED\tSTORED")
+	for _, p := range previews {
+		marker := ""
+		if p.current != p.normalized {
+			marker = " *"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s%s\n", p.method, p.host, p.original, p.normalized, p.current, marker)
+	}
+	w.Flush()
+
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("\n%d distinct paths -> %d routes (* = differs from stored path)\n", len(previews), len(names))
+	for _, name := range names {
+		fmt.Printf("  %-60s %d captures\n", name, templates[name])
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func TestPathNormalizerDefaults(t *testing.T) {
+	n, err := NewPathNormalizer(NormalizeConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/accounts/12345", "/accounts/{id}"},
+		{"/accounts/12345/transactions/678", "/accounts/{id}/transactions/{id}"},
+		{"/users/3f2504e0-4f89-11d3-9a0c-0305e82c3301", "/users/{id}"},
+		{"/customers/CUST-001", "/customers/{id}"},
+		{"/accounts/ACC-77/cards", "/accounts/{id}/cards"},
+		{"/tokens/tok_1a2b3c4d5e6f", "/tokens/{id}"},
+		{"/authorizations", "/authorizations"},
+		{"/v1/short9", "/v1/short9"},
+		{"/", "/"},
+		{"/accounts/", "/accounts/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := n.Normalize("api.example.com", tt.path); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathNormalizerRules(t *testing.T) {
+	config := NormalizeConfig{
+		Rules: []NormalizeRule{
+			{Host: "*.bank.test", After: "accounts", Pattern: `^[A-Z]{2}[0-9]{2}`, Param: "iban"},
+			{PathPrefix: "/orders", Pattern: `^ord_`, Param: "order_id"},
+			{Pattern: `^[a-z]+-[a-z]+$`, Param: "slug"},
+		},
+		Literals: []string{"2024", "api-docs"},
+	}
+	n, err := NewPathNormalizer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		path string
+		want string
+	}{
+		{"api.bank.test", "/accounts/GB82WEST12345698765432", "/accounts/{iban}"},
+		{"api.other.test", "/accounts/GB82WEST12345698765432", "/accounts/{id}"},
+		{"api.bank.test", "/cards/GB82WEST12345698765432", "/cards/{id}"},
+		{"api.example.com", "/orders/ord_abc", "/orders/{order_id}"},
+		{"api.example.com", "/refunds/ord_abc", "/refunds/ord_abc"},
+		{"api.example.com", "/posts/hello-world", "/posts/{slug}"},
+		{"api.example.com", "/reports/2024", "/reports/2024"},
+		{"api.example.com", "/api-docs", "/api-docs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host+tt.path, func(t *testing.T) {
+			if got := n.Normalize(tt.host, tt.path); got != tt.want {
+				t.Errorf("Normalize(%q, %q) = %q, want %q", tt.host, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathNormalizerDisableDefaults(t *testing.T) {
+	n, err := NewPathNormalizer(NormalizeConfig{
+		Rules:           []NormalizeRule{{Pattern: `^v[0-9]+$`, Param: "version"}},
+		DisableDefaults: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Normalize("", "/v2/accounts/12345"); got != "/{version}/accounts/12345" {
+		t.Errorf("got %q, want only the configured rule applied", got)
+	}
+}
+
+func TestNewPathNormalizerRejectsBadPattern(t *testing.T) {
+	if _, err := NewPathNormalizer(NormalizeConfig{Rules: []NormalizeRule{{Pattern: "("}}}); err == nil {
+		t.Error("want an error for an invalid pattern")
+	}
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		want   string
+		params int
+	}{
+		{"/accounts/{id}", "/accounts/{id}", 1},
+		{"/accounts/{id}/cards/{id}", "/accounts/{id}/cards/{id2}", 2},
+		{"/a/{id}/b/{slug}/c/{id}", "/a/{id}/b/{slug}/c/{id2}", 3},
+		{"/health", "/health", 0},
+	}
+	for _, tt := range tests {
+		got, params := openAPIPath(tt.path)
+		if got != tt.want || len(params) != tt.params {
+			t.Errorf("openAPIPath(%q) = %q %v, want %q with %d params", tt.path, got, params, tt.want, tt.params)
+		}
+	}
+}
@@ -0,0 +1,521 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// runOpenAPI implements the "openapi" command, which infers an OpenAPI 3
+// document from one or more saved capture files.
+func runOpenAPI(args []string) error {
+	fs := flag.NewFlagSet("openapi", flag.ExitOnError)
+	outPath := fs.String("out", "", "file to write the OpenAPI document to (default stdout)")
+	title := fs.String("title", "Captured API", "info.title of the generated document")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: openapi [-out spec.json] [-title name] captured/all-captured.json ...")
+	}
+
+	var captures []CapturedRoute
+	for _, file := range fs.Args() {
+		routes, err := loadCaptureFile(file)
+		if err != nil {
+			return err
+		}
+		captures = append(captures, routes...)
+	}
+
+	data, err := json.MarshalIndent(GenerateOpenAPI(captures, *title), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if *outPath == "" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	if err := os.WriteFile(*outPath, data, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Generated OpenAPI document from %d captures into %s", len(captures), *outPath)
+	return nil
+}
+
+// loadCaptureFile reads a file written by SaveCaptures.
+func loadCaptureFile(path string) ([]CapturedRoute, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Routes []CapturedRoute `json:"routes"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return file.Routes, nil
+}
+
+var templateParam = regexp.MustCompile(`^\{([^{}]+)\}$`)
+
+// GenerateOpenAPI groups captures by method and normalized path and merges the
+// observed requests and responses into an OpenAPI 3 document.
+func GenerateOpenAPI(captures []CapturedRoute, title string) map[string]interface{} {
+	paths := make(map[string]interface{})
+	servers := make(map[string]bool)
+
+	type operationKey struct{ path, method string }
+	groups := make(map[operationKey][]CapturedRoute)
+	var order []operationKey
+
+	for _, capture := range captures {
+		if capture.Method == http.MethodConnect {
+			continue
+		}
+		if u, err := url.Parse(capture.FullURL); err == nil && u.Host != "" {
+			servers[u.Scheme+"://"+u.Host] = true
+		}
+
+		path, _ := openAPIPath(capture.Path)
+		key := operationKey{path, strings.ToLower(capture.Method)}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], capture)
+	}
+
+	for _, key := range order {
+		item, _ := paths[key.path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[key.path] = item
+		}
+		item[key.method] = inferOperation(key.path, groups[key])
+	}
+
+	serverList := make([]interface{}, 0, len(servers))
+	for server := range servers {
+		serverList = append(serverList, map[string]interface{}{"url": server})
+	}
+	sort.Slice(serverList, func(i, j int) bool {
+		return serverList[i].(map[string]interface{})["url"].(string) < serverList[j].(map[string]interface{})["url"].(string)
+	})
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   title,
+			"version": "1.0.0",
+		},
+		"paths": paths,
+	}
+	if len(serverList) > 0 {
+		doc["servers"] = serverList
+	}
+	return doc
+}
+
+// openAPIPath makes the placeholders of a normalized path unique so each one
+// becomes its own path parameter: /a/{id}/b/{id} -> /a/{id}/b/{id2}.
+func openAPIPath(path string) (string, []string) {
+	parts := strings.Split(path, "/")
+	seen := make(map[string]int)
+	var params []string
+
+	for i, part := range parts {
+		match := templateParam.FindStringSubmatch(part)
+		if match == nil {
+			continue
+		}
+		name := match[1]
+		seen[name]++
+		if seen[name] > 1 {
+			name = fmt.Sprintf("%s%d", name, seen[name])
+		}
+		parts[i] = "{" + name + "}"
+		params = append(params, name)
+	}
+	return strings.Join(parts, "/"), params
+}
+
+func inferOperation(path string, samples []CapturedRoute) map[string]interface{} {
+	first := samples[0]
+	op := map[string]interface{}{
+		"summary":     fmt.Sprintf("%s %s", first.Method, path),
+		"operationId": operationID(first.Method, path),
+	}
+
+	var parameters []interface{}
+
+	_, pathParams := openAPIPath(path)
+	for _, name := range pathParams {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+
+	queryValues := make(map[string][]string)
+	for _, sample := range samples {
+		for name, value := range sample.QueryParams {
+			queryValues[name] = append(queryValues[name], value)
+		}
+	}
+	queryNames := make([]string, 0, len(queryValues))
+	for name := range queryValues {
+		queryNames = append(queryNames, name)
+	}
+	sort.Strings(queryNames)
+	for _, name := range queryNames {
+		values := queryValues[name]
+		parameters = append(parameters, map[string]interface{}{
+			"name":     name,
+			"in":       "query",
+			"required": len(values) == len(samples),
+			"schema":   inferQuerySchema(values),
+			"example":  values[0],
+		})
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	var requestSchema map[string]interface{}
+	var requestExample interface{}
+	for _, sample := range samples {
+		if sample.RequestBody == nil {
+			continue
+		}
+		requestSchema = mergeSchemas(requestSchema, inferSchema(sample.RequestBody))
+		if requestExample == nil {
+			requestExample = sample.RequestBody
+		}
+	}
+	if requestSchema != nil {
+		contentType := headerValue(first.RequestHeaders, "Content-Type")
+		op["requestBody"] = map[string]interface{}{
+			"content": map[string]interface{}{
+				mediaType(contentType, requestExample): map[string]interface{}{
+					"schema":  requestSchema,
+					"example": requestExample,
+				},
+			},
+		}
+	}
+
+	responses := make(map[string]interface{})
+	byStatus := make(map[int][]CapturedRoute)
+	for _, sample := range samples {
+		byStatus[sample.Status] = append(byStatus[sample.Status], sample)
+	}
+	for status, group := range byStatus {
+		response := map[string]interface{}{
+			"description": http.StatusText(status),
+		}
+
+		var schema map[string]interface{}
+		var example interface{}
+		for _, sample := range group {
+			if sample.Response == nil {
+				continue
+			}
+			schema = mergeSchemas(schema, inferSchema(sample.Response))
+			if example == nil {
+				example = sample.Response
+			}
+		}
+		if schema != nil {
+			contentType := headerValue(group[0].ResponseHeaders, "Content-Type")
+			response["content"] = map[string]interface{}{
+				mediaType(contentType, example): map[string]interface{}{
+					"schema":  schema,
+					"example": example,
+				},
+			}
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	op["responses"] = responses
+
+	return op
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, word := range nonIdentifier.Split(path, -1) {
+		if word != "" {
+			id += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return id
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+func mediaType(contentType string, example interface{}) string {
+	if contentType != "" {
+		return strings.TrimSpace(strings.Split(contentType, ";")[0])
+	}
+	if _, ok := example.(string); ok {
+		return "text/plain"
+	}
+	return "application/json"
+}
+
+var (
+	dateTimePattern = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:\d{2})$`)
+	datePattern     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	uuidPattern     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailPattern    = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+)
+
+// inferSchema describes a single decoded JSON value.
+func inferSchema(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case nil:
+		return map[string]interface{}{"nullable": true}
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	case float64:
+		if v == float64(int64(v)) {
+			return map[string]interface{}{"type": "integer"}
+		}
+		return map[string]interface{}{"type": "number"}
+	case string:
+		schema := map[string]interface{}{"type": "string"}
+		if format := stringFormat(v); format != "" {
+			schema["format"] = format
+		}
+		return schema
+	case []interface{}:
+		var items map[string]interface{}
+		for _, item := range v {
+			items = mergeSchemas(items, inferSchema(item))
+		}
+		if items == nil {
+			items = map[string]interface{}{}
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case map[string]interface{}:
+		properties := make(map[string]interface{})
+		required := make([]string, 0, len(v))
+		for name, prop := range v {
+			properties[name] = inferSchema(prop)
+			required = append(required, name)
+		}
+		sort.Strings(required)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+func stringFormat(s string) string {
+	switch {
+	case dateTimePattern.MatchString(s):
+		return "date-time"
+	case datePattern.MatchString(s):
+		return "date"
+	case uuidPattern.MatchString(s):
+		return "uuid"
+	case emailPattern.MatchString(s):
+		return "email"
+	}
+	return ""
+}
+
+// mergeSchemas widens a schema so that it accepts the samples described by
+// both a and b. Properties missing from either side stop being required.
+func mergeSchemas(a, b map[string]interface{}) map[string]interface{} {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+
+	nullable := a["nullable"] == true || b["nullable"] == true
+	typeA, _ := a["type"].(string)
+	typeB, _ := b["type"].(string)
+
+	var merged map[string]interface{}
+	switch {
+	case typeA == "":
+		merged = copySchema(b)
+		if _, ok := a["oneOf"]; ok {
+			merged = mergeOneOf(a, b)
+		}
+	case typeB == "":
+		merged = copySchema(a)
+	case typeA == typeB:
+		merged = mergeSameType(typeA, a, b)
+	case (typeA == "integer" && typeB == "number") || (typeA == "number" && typeB == "integer"):
+		merged = map[string]interface{}{"type": "number"}
+	default:
+		merged = mergeOneOf(a, b)
+	}
+
+	if nullable {
+		merged["nullable"] = true
+	}
+	return merged
+}
+
+func mergeSameType(schemaType string, a, b map[string]interface{}) map[string]interface{} {
+	merged := map[string]interface{}{"type": schemaType}
+
+	switch schemaType {
+	case "string":
+		if a["format"] != nil && a["format"] == b["format"] {
+			merged["format"] = a["format"]
+		}
+	case "array":
+		itemsA, _ := a["items"].(map[string]interface{})
+		itemsB, _ := b["items"].(map[string]interface{})
+		items := mergeSchemas(itemsA, itemsB)
+		if items == nil {
+			items = map[string]interface{}{}
+		}
+		merged["items"] = items
+	case "object":
+		propsA, _ := a["properties"].(map[string]interface{})
+		propsB, _ := b["properties"].(map[string]interface{})
+		properties := make(map[string]interface{})
+		for name, prop := range propsA {
+			properties[name] = prop
+		}
+		for name, prop := range propsB {
+			existing, _ := properties[name].(map[string]interface{})
+			properties[name] = mergeSchemas(existing, prop.(map[string]interface{}))
+		}
+		merged["properties"] = properties
+
+		requiredB := make(map[string]bool)
+		for _, name := range stringList(b["required"]) {
+			requiredB[name] = true
+		}
+		var required []string
+		for _, name := range stringList(a["required"]) {
+			if requiredB[name] {
+				required = append(required, name)
+			}
+		}
+		if len(required) > 0 {
+			merged["required"] = required
+		}
+	}
+	return merged
+}
+
+// mergeOneOf combines schemas of different types, folding each variant into
+// an existing variant of the same type where possible.
+func mergeOneOf(a, b map[string]interface{}) map[string]interface{} {
+	var variants []map[string]interface{}
+	for _, schema := range []map[string]interface{}{a, b} {
+		if options, ok := schema["oneOf"].([]interface{}); ok {
+			for _, option := range options {
+				variants = append(variants, option.(map[string]interface{}))
+			}
+			continue
+		}
+		variant := copySchema(schema)
+		delete(variant, "nullable")
+		if len(variant) > 0 {
+			variants = append(variants, variant)
+		}
+	}
+
+	var folded []interface{}
+	byType := make(map[string]int)
+	for _, variant := range variants {
+		variantType, _ := variant["type"].(string)
+		if i, ok := byType[variantType]; ok {
+			folded[i] = mergeSchemas(folded[i].(map[string]interface{}), variant)
+			continue
+		}
+		byType[variantType] = len(folded)
+		folded = append(folded, variant)
+	}
+
+	if len(folded) == 1 {
+		return folded[0].(map[string]interface{})
+	}
+	return map[string]interface{}{"oneOf": folded}
+}
+
+func copySchema(schema map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(schema))
+	for k, v := range schema {
+		c[k] = v
+	}
+	return c
+}
+
+func stringList(v interface{}) []string {
+	switch list := v.(type) {
+	case []string:
+		return list
+	case []interface{}:
+		var result []string
+		for _, item := range list {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// inferQuerySchema picks the narrowest type that fits every observed value of
+// a query parameter.
+func inferQuerySchema(values []string) map[string]interface{} {
+	allInt, allNumber, allBool := true, true, true
+	for _, value := range values {
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			allInt = false
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			allNumber = false
+		}
+		if value != "true" && value != "false" {
+			allBool = false
+		}
+	}
+
+	switch {
+	case allInt:
+		return map[string]interface{}{"type": "integer"}
+	case allNumber:
+		return map[string]interface{}{"type": "number"}
+	case allBool:
+		return map[string]interface{}{"type": "boolean"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
@@ -0,0 +1,79 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// originalDestination returns the address a connection was sent to before
+// an iptables REDIRECT or DNAT rule rewrote it (SO_ORIGINAL_DST).
+func originalDestination(conn net.Conn) (string, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return "", errors.New("not a TCP connection")
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return "", err
+	}
+
+	ipv6 := false
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && local.IP.To4() == nil {
+		ipv6 = true
+	}
+
+	var addr string
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv6 {
+			// IP6T_SO_ORIGINAL_DST shares SO_ORIGINAL_DST's value and fills
+			// a sockaddr_in6, which IPv6MTUInfo starts with
+			info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, unix.SO_ORIGINAL_DST)
+			if err != nil {
+				sockErr = err
+				return
+			}
+			port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			addr = net.JoinHostPort(net.IP(info.Addr.Addr[:]).String(), strconv.Itoa(int(port[0])<<8|int(port[1])))
+			return
+		}
+
+		// The sockaddr_in result fits in the 16 bytes of an IPv6Mreq
+		mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+		if err != nil {
+			sockErr = err
+			return
+		}
+		sa := mreq.Multiaddr
+		addr = net.JoinHostPort(net.IPv4(sa[4], sa[5], sa[6], sa[7]).String(), strconv.Itoa(int(sa[2])<<8|int(sa[3])))
+	})
+	if err != nil {
+		return "", err
+	}
+	return addr, sockErr
+}
+
+// tproxyControl marks a listening socket IP_TRANSPARENT so that it accepts
+// connections routed to it by an iptables TPROXY rule. The accepted
+// connection's local address is then the original destination. Requires
+// CAP_NET_ADMIN.
+func tproxyControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+		if sockErr == nil && network == "tcp6" {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var errNotLinux = errors.New("original destination lookup is only supported on Linux")
+
+func originalDestination(conn net.Conn) (string, error) {
+	return "", errNotLinux
+}
+
+func tproxyControl(network, address string, c syscall.RawConn) error {
+	return errNotLinux
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProtectionSettings guard one upstream host. After FailureThreshold
+// consecutive failures (transport errors, timeouts or a FailureStatuses
+// response) the host's circuit opens and requests are refused with 503 for
+// OpenSeconds. The circuit then goes half-open: up to HalfOpenProbes requests
+// are let through, and SuccessThreshold successes close it again while any
+// failure reopens it. MaxConcurrent caps the requests in flight to the host,
+// counting each open CONNECT or transparent tunnel as one for as long as it
+// stays open; extra requests wait up to QueueTimeoutMs for a slot. TimeoutSeconds bounds
+// each upstream request except gRPC calls, whose streams may be long-lived.
+type ProtectionSettings struct {
+	Disabled         bool    `json:"disabled,omitempty"`
+	FailureThreshold int     `json:"failure_threshold,omitempty"`
+	FailureStatuses  []int   `json:"failure_statuses,omitempty"`
+	OpenSeconds      float64 `json:"open_seconds,omitempty"`
+	HalfOpenProbes   int     `json:"half_open_probes,omitempty"`
+	SuccessThreshold int     `json:"success_threshold,omitempty"`
+	MaxConcurrent    int     `json:"max_concurrent,omitempty"`
+	QueueTimeoutMs   int     `json:"queue_timeout_ms,omitempty"`
+	TimeoutSeconds   float64 `json:"timeout_seconds,omitempty"`
+}
+
+// ProtectionConfig holds the default settings and per-host overrides. Hosts
+// are matched against "host:port" or the bare hostname, with path.Match
+// globs such as "*.example.com"; an override replaces the defaults for that
+// host.
+type ProtectionConfig struct {
+	ProtectionSettings
+	Hosts map[string]ProtectionSettings `json:"hosts,omitempty"`
+}
+
+// defaultProtection matches the limits of the old mitmproxy capture script.
+var defaultProtection = ProtectionSettings{
+	FailureThreshold: 5,
+	FailureStatuses:  []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+	OpenSeconds:      30,
+	HalfOpenProbes:   1,
+	SuccessThreshold: 1,
+}
+
+// withDefaults fills the zero fields of s from defaultProtection.
+func (s ProtectionSettings) withDefaults() ProtectionSettings {
+	if s.FailureThreshold == 0 {
+		s.FailureThreshold = defaultProtection.FailureThreshold
+	}
+	if s.FailureStatuses == nil {
+		s.FailureStatuses = defaultProtection.FailureStatuses
+	}
+	if s.OpenSeconds == 0 {
+		s.OpenSeconds = defaultProtection.OpenSeconds
+	}
+	if s.HalfOpenProbes == 0 {
+		s.HalfOpenProbes = defaultProtection.HalfOpenProbes
+	}
+	if s.SuccessThreshold == 0 {
+		s.SuccessThreshold = defaultProtection.SuccessThreshold
+	}
+	return s
+}
+
+func (s ProtectionSettings) validate() error {
+	if s.FailureThreshold < 0 || s.OpenSeconds < 0 || s.HalfOpenProbes < 0 || s.SuccessThreshold < 0 {
+		return fmt.Errorf("breaker settings must not be negative")
+	}
+	if s.MaxConcurrent < 0 || s.QueueTimeoutMs < 0 || s.TimeoutSeconds < 0 {
+		return fmt.Errorf("concurrency and timeout settings must not be negative")
+	}
+	return nil
+}
+
+// UpstreamGuard keeps a circuit breaker and concurrency limit per upstream
+// host.
+type UpstreamGuard struct {
+	config ProtectionConfig
+	mu     sync.Mutex
+	hosts  map[string]*hostGuard
+}
+
+// hostGuard is the state of one upstream host.
+type hostGuard struct {
+	settings  ProtectionSettings
+	slots     chan struct{}
+	state     string // "closed", "open" or "half-open"
+	failures  int
+	successes int
+	probes    int
+	openedAt  time.Time
+	rejected  int
+	lastError string
+}
+
+func NewUpstreamGuard(config ProtectionConfig) (*UpstreamGuard, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	for host, settings := range config.Hosts {
+		if _, err := path.Match(host, ""); err != nil {
+			return nil, fmt.Errorf("invalid host pattern %q: %w", host, err)
+		}
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("host %s: %w", host, err)
+		}
+	}
+	return &UpstreamGuard{config: config, hosts: make(map[string]*hostGuard)}, nil
+}
+
+func LoadUpstreamGuard(configPath string) (*UpstreamGuard, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	var config ProtectionConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+	return NewUpstreamGuard(config)
+}
+
+// LoadUpstreamProtection replaces the default breaker and concurrency
+// settings with the ones in configPath.
+func (cp *CaptureProxy) LoadUpstreamProtection(configPath string) error {
+	guard, err := LoadUpstreamGuard(configPath)
+	if err != nil {
+		return err
+	}
+	cp.guard = guard
+	log.Printf("Loaded upstream protection settings from %s", configPath)
+	return nil
+}
+
+// settingsFor returns the settings that apply to host ("host:port").
+func (g *UpstreamGuard) settingsFor(host string) ProtectionSettings {
+	hostname := host
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		hostname = name
+	}
+	if settings, ok := g.config.Hosts[host]; ok {
+		return settings.withDefaults()
+	}
+	if settings, ok := g.config.Hosts[hostname]; ok {
+		return settings.withDefaults()
+	}
+
+	// Longest pattern wins so "api.*.example.com" beats "*.example.com"
+	patterns := make([]string, 0, len(g.config.Hosts))
+	for pattern := range g.config.Hosts {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool { return len(patterns[i]) > len(patterns[j]) })
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, host); matched {
+			return g.config.Hosts[pattern].withDefaults()
+		}
+		if matched, _ := path.Match(pattern, hostname); matched {
+			return g.config.Hosts[pattern].withDefaults()
+		}
+	}
+	return g.config.ProtectionSettings.withDefaults()
+}
+
+func (g *UpstreamGuard) host(host string) *hostGuard {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	h, ok := g.hosts[host]
+	if !ok {
+		h = &hostGuard{settings: g.settingsFor(host), state: "closed"}
+		if h.settings.MaxConcurrent > 0 {
+			h.slots = make(chan struct{}, h.settings.MaxConcurrent)
+		}
+		g.hosts[host] = h
+	}
+	return h
+}
+
+// UnavailableError explains why a request was not sent upstream.
+type UnavailableError struct {
+	Host       string
+	Reason     string // "circuit-open" or "concurrency-limit"
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	if e.Reason == "circuit-open" {
+		return fmt.Sprintf("circuit open for %s: upstream is failing", e.Host)
+	}
+	return fmt.Sprintf("too many concurrent requests to %s", e.Host)
+}
+
+// upstreamTicket is a request let through to a host. done must be called
+// with the outcome, or result and then release for tunnels, which report
+// the dial outcome but keep their slot until they close.
+type upstreamTicket struct {
+	guard       *UpstreamGuard
+	host        string
+	h           *hostGuard
+	probe       bool
+	slot        bool
+	resultOnce  sync.Once
+	releaseOnce sync.Once
+	timeout     time.Duration
+}
+
+// acquire checks host's breaker and takes a concurrency slot, waiting up to
+// the queue timeout for one.
+func (g *UpstreamGuard) acquire(host string) (*upstreamTicket, error) {
+	h := g.host(host)
+	ticket := &upstreamTicket{guard: g, host: host, h: h}
+	if h.settings.Disabled {
+		return ticket, nil
+	}
+	ticket.timeout = time.Duration(h.settings.TimeoutSeconds * float64(time.Second))
+
+	g.mu.Lock()
+	if h.state == "open" {
+		openFor := time.Duration(h.settings.OpenSeconds * float64(time.Second))
+		if wait := openFor - time.Since(h.openedAt); wait > 0 {
+			h.rejected++
+			g.mu.Unlock()
+			return nil, &UnavailableError{Host: host, Reason: "circuit-open", RetryAfter: wait}
+		}
+		h.state, h.probes, h.successes = "half-open", 0, 0
+		log.Printf("🟡 Circuit half-open for %s, probing", host)
+	}
+	if h.state == "half-open" {
+		if h.probes >= h.settings.HalfOpenProbes {
+			h.rejected++
+			g.mu.Unlock()
+			return nil, &UnavailableError{Host: host, Reason: "circuit-open", RetryAfter: time.Second}
+		}
+		h.probes++
+		ticket.probe = true
+	}
+	g.mu.Unlock()
+
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		default:
+			timer := time.NewTimer(time.Duration(h.settings.QueueTimeoutMs) * time.Millisecond)
+			defer timer.Stop()
+			select {
+			case h.slots <- struct{}{}:
+			case <-timer.C:
+				g.mu.Lock()
+				h.rejected++
+				if ticket.probe {
+					h.probes--
+				}
+				g.mu.Unlock()
+				return nil, &UnavailableError{Host: host, Reason: "concurrency-limit", RetryAfter: time.Second}
+			}
+		}
+		ticket.slot = true
+	}
+	return ticket, nil
+}
+
+// failed reports whether an upstream status counts against the breaker.
+func (t *upstreamTicket) failed(status int) bool {
+	for _, failure := range t.h.settings.FailureStatuses {
+		if status == failure {
+			return true
+		}
+	}
+	return false
+}
+
+// done feeds the outcome to the breaker and releases the ticket's slot.
+func (t *upstreamTicket) done(failed bool, err error) {
+	t.result(failed, err)
+	t.release()
+}
+
+// release gives the ticket's concurrency slot back.
+func (t *upstreamTicket) release() {
+	t.releaseOnce.Do(func() {
+		if t.slot {
+			<-t.h.slots
+		}
+	})
+}
+
+// result feeds the outcome to the breaker; err is the transport error, if
+// any.
+func (t *upstreamTicket) result(failed bool, err error) {
+	t.resultOnce.Do(func() {
+		if t.h.settings.Disabled {
+			return
+		}
+
+		g, h := t.guard, t.h
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if t.probe {
+			h.probes--
+		}
+		if !failed {
+			h.failures = 0
+			if h.state == "half-open" {
+				h.successes++
+				if h.successes >= h.settings.SuccessThreshold {
+					h.state = "closed"
+					log.Printf("🟢 Circuit closed for %s", t.host)
+				}
+			}
+			return
+		}
+
+		if err != nil {
+			h.lastError = err.Error()
+		}
+		h.failures++
+		if h.state == "half-open" || (h.state == "closed" && h.failures >= h.settings.FailureThreshold) {
+			h.state, h.openedAt = "open", time.Now()
+			log.Printf("🔴 Circuit opened for %s after %d failures", t.host, h.failures)
+		}
+	})
+}
+
+// HostStatus is the breaker state of a host as shown on /capture/status.
+type HostStatus struct {
+	State         string `json:"state"`
+	Failures      int    `json:"consecutive_failures"`
+	InFlight      int    `json:"in_flight"`
+	MaxConcurrent int    `json:"max_concurrent,omitempty"`
+	Rejected      int    `json:"rejected"`
+	RetryAfter    int    `json:"retry_after_seconds,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+// Status reports every host the proxy has sent requests to.
+func (g *UpstreamGuard) Status() map[string]HostStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	status := make(map[string]HostStatus, len(g.hosts))
+	for host, h := range g.hosts {
+		hs := HostStatus{
+			State:         h.state,
+			Failures:      h.failures,
+			InFlight:      len(h.slots),
+			MaxConcurrent: h.settings.MaxConcurrent,
+			Rejected:      h.rejected,
+			LastError:     h.lastError,
+		}
+		if h.settings.Disabled {
+			hs.State = "disabled"
+		}
+		if h.state == "open" {
+			openFor := time.Duration(h.settings.OpenSeconds * float64(time.Second))
+			if wait := openFor - time.Since(h.openedAt); wait > 0 {
+				hs.RetryAfter = int(math.Ceil(wait.Seconds()))
+			}
+		}
+		status[host] = hs
+	}
+	return status
+}
+
+// guardHost takes a ticket for the host of targetURL, answering the client
+// itself when the host is unavailable.
+func (cp *CaptureProxy) guardHost(w http.ResponseWriter, r *http.Request, targetURL string) (*upstreamTicket, bool) {
+	parsedURL, err := url.Parse(targetURL)
+	if err != nil {
+		return &upstreamTicket{h: &hostGuard{settings: ProtectionSettings{Disabled: true}}}, true
+	}
+	host := parsedURL.Host
+	if parsedURL.Port() == "" {
+		port := "80"
+		if parsedURL.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(parsedURL.Hostname(), port)
+	}
+
+	ticket, err := cp.guard.acquire(host)
+	if err != nil {
+		rejectUnavailable(w, r, err.(*UnavailableError))
+		return nil, false
+	}
+	return ticket, true
+}
+
+// rejectUnavailable answers for an unavailable upstream with 503, or
+// UNAVAILABLE for gRPC calls.
+func rejectUnavailable(w http.ResponseWriter, r *http.Request, err *UnavailableError) {
+	log.Printf("⛔ %s %s not forwarded: %v", r.Method, r.URL.String(), err)
+	seconds := int(math.Ceil(err.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	w.Header().Set("X-Capture-Proxy-Rejected", err.Reason)
+	if isGRPC(r) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "14")
+		w.Header().Set("Grpc-Message", url.PathEscape(err.Error()))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusServiceUnavailable)
+}
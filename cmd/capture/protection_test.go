@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestGuard(t *testing.T, config ProtectionConfig) *UpstreamGuard {
+	t.Helper()
+	g, err := NewUpstreamGuard(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// expireOpen moves host's open period into the past, as if OpenSeconds had
+// elapsed.
+func expireOpen(g *UpstreamGuard, host string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	h := g.hosts[host]
+	h.openedAt = h.openedAt.Add(-time.Duration(h.settings.OpenSeconds*float64(time.Second)) - time.Millisecond)
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	const host = "api.example.com:443"
+
+	// Each step is "ok", "fail", "reject" (acquire must be refused) or
+	// "expire" (the open period runs out); state is checked after it.
+	tests := []struct {
+		name     string
+		settings ProtectionSettings
+		steps    []string
+		states   []string
+	}{
+		{
+			name:     "opens after the failure threshold",
+			settings: ProtectionSettings{FailureThreshold: 3},
+			steps:    []string{"fail", "fail", "fail", "reject"},
+			states:   []string{"closed", "closed", "open", "open"},
+		},
+		{
+			name:     "a success resets the failure count",
+			settings: ProtectionSettings{FailureThreshold: 2},
+			steps:    []string{"fail", "ok", "fail", "ok"},
+			states:   []string{"closed", "closed", "closed", "closed"},
+		},
+		{
+			name:     "a half-open success closes the circuit",
+			settings: ProtectionSettings{FailureThreshold: 1},
+			steps:    []string{"fail", "expire", "ok", "ok"},
+			states:   []string{"open", "open", "closed", "closed"},
+		},
+		{
+			name:     "a half-open failure reopens the circuit",
+			settings: ProtectionSettings{FailureThreshold: 1},
+			steps:    []string{"fail", "expire", "fail", "reject"},
+			states:   []string{"open", "open", "open", "open"},
+		},
+		{
+			name:     "success threshold needs several probes",
+			settings: ProtectionSettings{FailureThreshold: 1, SuccessThreshold: 2},
+			steps:    []string{"fail", "expire", "ok", "ok"},
+			states:   []string{"open", "open", "half-open", "closed"},
+		},
+		{
+			name:     "disabled never opens",
+			settings: ProtectionSettings{Disabled: true, FailureThreshold: 1},
+			steps:    []string{"fail", "fail", "ok"},
+			states:   []string{"disabled", "disabled", "disabled"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGuard(t, ProtectionConfig{ProtectionSettings: tt.settings})
+			for i, step := range tt.steps {
+				switch step {
+				case "expire":
+					expireOpen(g, host)
+				case "reject":
+					var unavailable *UnavailableError
+					if _, err := g.acquire(host); !errors.As(err, &unavailable) || unavailable.Reason != "circuit-open" {
+						t.Fatalf("step %d: acquire = %v, want circuit-open", i, err)
+					}
+				default:
+					ticket, err := g.acquire(host)
+					if err != nil {
+						t.Fatalf("step %d: acquire: %v", i, err)
+					}
+					if step == "fail" {
+						ticket.done(true, errors.New("connection refused"))
+					} else {
+						ticket.done(false, nil)
+					}
+				}
+				if got := g.Status()[host].State; got != tt.states[i] {
+					t.Fatalf("step %d (%s): state = %q, want %q", i, step, got, tt.states[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerLimitsHalfOpenProbes(t *testing.T) {
+	const host = "api.example.com:443"
+	g := newTestGuard(t, ProtectionConfig{ProtectionSettings: ProtectionSettings{FailureThreshold: 1, HalfOpenProbes: 1}})
+
+	ticket, err := g.acquire(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticket.done(true, nil)
+	expireOpen(g, host)
+
+	probe, err := g.acquire(host)
+	if err != nil {
+		t.Fatalf("probe refused: %v", err)
+	}
+	if _, err := g.acquire(host); err == nil {
+		t.Fatal("second request let through while the probe is in flight")
+	}
+	probe.done(false, nil)
+	if _, err := g.acquire(host); err != nil {
+		t.Fatalf("closed circuit refused a request: %v", err)
+	}
+}
+
+func TestUpstreamTicketFailedStatuses(t *testing.T) {
+	g := newTestGuard(t, ProtectionConfig{})
+	ticket, err := g.acquire("api.example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		status int
+		failed bool
+	}{
+		{http.StatusOK, false},
+		{http.StatusNotFound, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusBadGateway, true},
+		{http.StatusServiceUnavailable, true},
+		{http.StatusGatewayTimeout, true},
+	}
+	for _, tt := range tests {
+		if got := ticket.failed(tt.status); got != tt.failed {
+			t.Errorf("failed(%d) = %v, want %v", tt.status, got, tt.failed)
+		}
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	const host = "slow.internal:80"
+	g := newTestGuard(t, ProtectionConfig{Hosts: map[string]ProtectionSettings{
+		"*.internal": {MaxConcurrent: 1, QueueTimeoutMs: 10},
+	}})
+
+	first, err := g.acquire(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unavailable *UnavailableError
+	if _, err := g.acquire(host); !errors.As(err, &unavailable) || unavailable.Reason != "concurrency-limit" {
+		t.Fatalf("acquire = %v, want concurrency-limit", err)
+	}
+	first.done(false, nil)
+	if _, err := g.acquire(host); err != nil {
+		t.Fatalf("slot not released: %v", err)
+	}
+	if status := g.Status()[host]; status.Rejected != 1 || status.InFlight != 1 {
+		t.Errorf("status = %+v, want 1 rejected and 1 in flight", status)
+	}
+}
+
+func TestSettingsForPrefersLongestPattern(t *testing.T) {
+	g := newTestGuard(t, ProtectionConfig{
+		ProtectionSettings: ProtectionSettings{FailureThreshold: 9},
+		Hosts: map[string]ProtectionSettings{
+			"*.example.com":     {FailureThreshold: 2},
+			"api.*.example.com": {FailureThreshold: 3},
+			"exact.example.com": {FailureThreshold: 4},
+		},
+	})
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"www.example.com:443", 2},
+		{"api.eu.example.com:443", 3},
+		{"exact.example.com:443", 4},
+		{"other.org:443", 9},
+	}
+	for _, tt := range tests {
+		if got := g.settingsFor(tt.host).FailureThreshold; got != tt.want {
+			t.Errorf("settingsFor(%q).FailureThreshold = %d, want %d", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestTunnelTicketHoldsSlotUntilReleased(t *testing.T) {
+	const host = "db.internal:5432"
+	g := newTestGuard(t, ProtectionConfig{ProtectionSettings: ProtectionSettings{
+		FailureThreshold: 1,
+		MaxConcurrent:    1,
+		QueueTimeoutMs:   10,
+	}})
+
+	tunnel, err := g.acquire(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunnel.result(false, nil)
+	if _, err := g.acquire(host); err == nil {
+		t.Fatal("second tunnel let through while the first is open")
+	}
+	if status := g.Status()[host]; status.InFlight != 1 || status.State != "closed" {
+		t.Errorf("status = %+v, want 1 in flight and closed", status)
+	}
+
+	tunnel.release()
+	tunnel.release() // closing twice must not free a slot it doesn't hold
+	next, err := g.acquire(host)
+	if err != nil {
+		t.Fatalf("slot not released: %v", err)
+	}
+	if status := g.Status()[host]; status.InFlight != 1 {
+		t.Errorf("in flight = %d, want 1", status.InFlight)
+	}
+
+	// A failed dial opens the circuit even before the slot is released
+	next.result(true, errors.New("connection refused"))
+	next.done(false, nil) // the outcome is only counted once
+	if state := g.Status()[host].State; state != "open" {
+		t.Errorf("state = %q, want open", state)
+	}
+}
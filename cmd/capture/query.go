@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultQueryLimit = 100
+	maxQueryLimit     = 1000
+)
+
+// CaptureQuery selects captures for /capture/query. Zero values mean "no
+// constraint". Path is a glob where * stays within a segment and ** spans
+// segments; it is matched against both the normalized and the raw path.
+type CaptureQuery struct {
+	Source     string // memory (default), files or all
+	Methods    []string
+	Host       string
+	Path       string
+	Operation  string
+	StatusMin  int
+	StatusMax  int
+	LatencyMin int64
+	LatencyMax int64
+	From       time.Time
+	To         time.Time
+	Text       string
+	JSONPath   string
+	Value      *string
+	Sort       string
+	Descending bool
+	Offset     int
+	Limit      int
+
+	pathRe   *regexp.Regexp
+	jsonPath []pathSegment
+}
+
+// QueryResult is one page of matching captures. Source is "memory" or the
+// capture file the entry was read from.
+type QueryResult struct {
+	Total   int          `json:"total"`
+	Offset  int          `json:"offset"`
+	Limit   int          `json:"limit"`
+	Results []QueryMatch `json:"results"`
+}
+
+type QueryMatch struct {
+	Source  string        `json:"source"`
+	Capture CapturedRoute `json:"capture"`
+}
+
+var querySortFields = map[string]func(a, b CapturedRoute) bool{
+	"captured_at":   func(a, b CapturedRoute) bool { return a.CapturedAt.Before(b.CapturedAt) },
+	"response_time": func(a, b CapturedRoute) bool { return a.ResponseTime < b.ResponseTime },
+	"status":        func(a, b CapturedRoute) bool { return a.Status < b.Status },
+	"method":        func(a, b CapturedRoute) bool { return a.Method < b.Method },
+	"path":          func(a, b CapturedRoute) bool { return a.Path < b.Path },
+	"host":          func(a, b CapturedRoute) bool { return a.Host < b.Host },
+}
+
+// ParseCaptureQuery reads a CaptureQuery from URL parameters:
+//
+//	source, method (comma-separated), host, path, status_min, status_max,
+//	latency_min, latency_max (ms), from, to (RFC 3339, or a duration such as
+//	15m meaning that long ago), q (full text), jsonpath, value,
+//	sort (prefix with - for descending), offset, limit (1-1000, default 100)
+func ParseCaptureQuery(values url.Values) (*CaptureQuery, error) {
+	q := &CaptureQuery{
+		Source:    values.Get("source"),
+		Host:      values.Get("host"),
+		Path:      values.Get("path"),
+		Operation: values.Get("operation"),
+		Text:      values.Get("q"),
+		Sort:      values.Get("sort"),
+		Limit:     defaultQueryLimit,
+	}
+
+	switch q.Source {
+	case "":
+		q.Source = "memory"
+	case "memory", "files", "all":
+	default:
+		return nil, fmt.Errorf("invalid source %q (want memory, files or all)", q.Source)
+	}
+
+	for _, method := range strings.Split(values.Get("method"), ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			q.Methods = append(q.Methods, method)
+		}
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{
+		{"status_min", &q.StatusMin},
+		{"status_max", &q.StatusMax},
+		{"offset", &q.Offset},
+		{"limit", &q.Limit},
+	} {
+		if raw := values.Get(param.name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", param.name, raw)
+			}
+			*param.target = n
+		}
+	}
+	if q.Limit == 0 {
+		return nil, fmt.Errorf("invalid limit %q: must be at least 1", values.Get("limit"))
+	}
+	if q.Limit > maxQueryLimit {
+		q.Limit = maxQueryLimit
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *int64
+	}{
+		{"latency_min", &q.LatencyMin},
+		{"latency_max", &q.LatencyMax},
+	} {
+		if raw := values.Get(param.name); raw != "" {
+			n, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", param.name, raw)
+			}
+			*param.target = n
+		}
+	}
+
+	for _, param := range []struct {
+		name   string
+		target *time.Time
+	}{
+		{"from", &q.From},
+		{"to", &q.To},
+	} {
+		raw := values.Get(param.name)
+		if raw == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, raw); err == nil {
+			*param.target = t
+		} else if d, err := time.ParseDuration(raw); err == nil {
+			*param.target = time.Now().Add(-d)
+		} else {
+			return nil, fmt.Errorf("invalid %s %q (want RFC 3339 time or duration)", param.name, raw)
+		}
+	}
+
+	if strings.HasPrefix(q.Sort, "-") {
+		q.Sort, q.Descending = q.Sort[1:], true
+	}
+	if q.Sort == "" {
+		q.Sort = "captured_at"
+	}
+	if _, ok := querySortFields[q.Sort]; !ok {
+		return nil, fmt.Errorf("invalid sort %q", q.Sort)
+	}
+
+	if q.Path != "" {
+		q.pathRe = globRegexp(q.Path)
+	}
+	if expr := values.Get("jsonpath"); expr != "" {
+		segments, err := parseJSONPath(expr)
+		if err != nil {
+			return nil, err
+		}
+		q.JSONPath, q.jsonPath = expr, segments
+		if values.Has("value") {
+			value := values.Get("value")
+			q.Value = &value
+		}
+	}
+
+	return q, nil
+}
+
+// globRegexp converts a path glob to an anchored regular expression.
+func globRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (q *CaptureQuery) matches(capture CapturedRoute) bool {
+	host, rawPath := capture.Host, capture.Path
+	if u, err := url.Parse(capture.FullURL); err == nil && u.Host != "" {
+		host, rawPath = u.Host, u.Path
+	}
+
+	if len(q.Methods) > 0 && !containsFold(q.Methods, capture.Method) {
+		return false
+	}
+	if q.Host != "" && !hostMatches(q.Host, host) {
+		return false
+	}
+	if q.pathRe != nil && !q.pathRe.MatchString(capture.Path) && !q.pathRe.MatchString(rawPath) {
+		return false
+	}
+	if q.Operation != "" && (capture.GraphQL == nil || !strings.EqualFold(capture.GraphQL.OperationName, q.Operation)) {
+		return false
+	}
+	if (q.StatusMin > 0 && capture.Status < q.StatusMin) || (q.StatusMax > 0 && capture.Status > q.StatusMax) {
+		return false
+	}
+	if (q.LatencyMin > 0 && capture.ResponseTime < q.LatencyMin) || (q.LatencyMax > 0 && capture.ResponseTime > q.LatencyMax) {
+		return false
+	}
+	if (!q.From.IsZero() && capture.CapturedAt.Before(q.From)) || (!q.To.IsZero() && capture.CapturedAt.After(q.To)) {
+		return false
+	}
+	if q.Text != "" && !captureContains(capture, q.Text) {
+		return false
+	}
+	if q.jsonPath != nil && !q.jsonPathMatches(capture.RequestBody) && !q.jsonPathMatches(capture.Response) {
+		return false
+	}
+	return true
+}
+
+// captureContains is a case-insensitive search of the URL and both bodies.
+func captureContains(capture CapturedRoute, text string) bool {
+	text = strings.ToLower(text)
+	if strings.Contains(strings.ToLower(capture.FullURL), text) {
+		return true
+	}
+	for _, body := range []interface{}{capture.RequestBody, capture.Response} {
+		if body == nil {
+			continue
+		}
+		data, err := json.Marshal(body)
+		if err == nil && strings.Contains(strings.ToLower(string(data)), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPathMatches reports whether the JSONPath selects anything in body and,
+// when a value was given, whether any selected value equals it.
+func (q *CaptureQuery) jsonPathMatches(body interface{}) bool {
+	found := false
+	applyJSONPath(body, q.jsonPath, func(v interface{}) interface{} {
+		if q.Value == nil {
+			found = true
+		} else if s, ok := v.(string); ok {
+			found = found || s == *q.Value
+		} else if data, err := json.Marshal(v); err == nil {
+			found = found || string(data) == *q.Value
+		}
+		return v
+	})
+	return found
+}
+
+// Query runs q against the in-memory captures and/or the capture files under
+// the output directory.
+func (cp *CaptureProxy) Query(q *CaptureQuery) QueryResult {
+	var matches []QueryMatch
+	seen := make(map[string]bool)
+
+	if q.Source == "memory" || q.Source == "all" {
+		cp.mu.Lock()
+		captures := make([]CapturedRoute, len(cp.captures))
+		copy(captures, cp.captures)
+		cp.mu.Unlock()
+
+		for _, capture := range captures {
+			seen[captureKey(capture)] = true
+			if q.matches(capture) {
+				matches = append(matches, QueryMatch{Source: "memory", Capture: capture})
+			}
+		}
+	}
+
+	if q.Source == "files" || q.Source == "all" {
+		matches = append(matches, cp.queryFiles(q, seen)...)
+	}
+
+	less := querySortFields[q.Sort]
+	sort.SliceStable(matches, func(i, j int) bool {
+		if q.Descending {
+			return less(matches[j].Capture, matches[i].Capture)
+		}
+		return less(matches[i].Capture, matches[j].Capture)
+	})
+
+	result := QueryResult{Total: len(matches), Offset: q.Offset, Limit: q.Limit, Results: []QueryMatch{}}
+	if q.Offset < len(matches) {
+		end := q.Offset + q.Limit
+		if end > len(matches) {
+			end = len(matches)
+		}
+		result.Results = matches[q.Offset:end]
+	}
+	return result
+}
+
+// captureKey identifies a capture across memory and the saved files. It
+// leaves out the URL and bodies, which anonymization rewrites on save.
+func captureKey(capture CapturedRoute) string {
+	return fmt.Sprintf("%s %d %d", capture.Method, capture.CapturedAt.UnixNano(), capture.Status)
+}
+
+// queryFiles searches every capture file under the output directory,
+// skipping captures in seen: captures still in memory may already be saved
+// (a query racing SaveCaptures, or a save that failed part way), and the
+// combined all-captured.json repeats the per-service files, so it is read
+// last.
+func (cp *CaptureProxy) queryFiles(q *CaptureQuery, seen map[string]bool) []QueryMatch {
+	var files []string
+	filepath.Walk(cp.outputDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && strings.HasSuffix(path, ".json") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		return filepath.Base(files[i]) != "all-captured.json" && filepath.Base(files[j]) == "all-captured.json"
+	})
+
+	var matches []QueryMatch
+	for _, path := range files {
+		captures, err := loadCaptureFile(path)
+		if err != nil {
+			log.Printf("⚠️  Skipping %s in capture query: %v", path, err)
+			continue
+		}
+
+		rel, _ := filepath.Rel(cp.outputDir, path)
+		for _, capture := range captures {
+			key := captureKey(capture)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if q.matches(capture) {
+				matches = append(matches, QueryMatch{Source: rel, Capture: capture})
+			}
+		}
+	}
+	return matches
+}
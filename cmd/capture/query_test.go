@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func decodeJSON(t *testing.T, data string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(data), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"/accounts/*", "/accounts/42", true},
+		{"/accounts/*", "/accounts/42/cards", false},
+		{"/accounts/**", "/accounts/42/cards", true},
+		{"/accounts/**", "/accounts", false},
+		{"/accounts/?", "/accounts/4", true},
+		{"/accounts/?", "/accounts/42", false},
+		{"/v1.0/*", "/v1x0/a", false},
+		{"/search", "/search/more", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob+" "+tt.path, func(t *testing.T) {
+			if got := globRegexp(tt.glob).MatchString(tt.path); got != tt.match {
+				t.Errorf("got %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"$.card.status", false},
+		{"$.items[*].id", false},
+		{"$.items[0]", false},
+		{"$['card number']", false},
+		{"$..token", false},
+		{"card.status", true},
+		{"$.items[", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseJSONPath(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJSONPathMatches(t *testing.T) {
+	body := decodeJSON(t, `{
+		"card": {"status": "blocked", "limit": 500},
+		"items": [{"id": "a"}, {"id": "b"}],
+		"nested": {"deep": {"token": "t1"}},
+		"card number": "4111"
+	}`)
+
+	tests := []struct {
+		expr  string
+		value string // "" means any selected value matches
+		match bool
+	}{
+		{"$.card.status", "blocked", true},
+		{"$.card.status", "active", false},
+		{"$.card.limit", "500", true},
+		{"$.card.missing", "", false},
+		{"$.card.status", "", true},
+		{"$.items[*].id", "b", true},
+		{"$.items[1].id", "a", false},
+		{"$.items.id", "a", true},
+		{"$..token", "t1", true},
+		{"$['card number']", "4111", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr+"="+tt.value, func(t *testing.T) {
+			values := url.Values{"jsonpath": {tt.expr}}
+			if tt.value != "" {
+				values.Set("value", tt.value)
+			}
+			q, err := ParseCaptureQuery(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.jsonPathMatches(body); got != tt.match {
+				t.Errorf("got %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
+
+func TestParseCaptureQueryLimit(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    int
+		wantErr bool
+	}{
+		{"", defaultQueryLimit, false},
+		{"50", 50, false},
+		{"5000", maxQueryLimit, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"ten", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			values := url.Values{}
+			if tt.raw != "" {
+				values.Set("limit", tt.raw)
+			}
+			q, err := ParseCaptureQuery(values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && q.Limit != tt.want {
+				t.Errorf("limit = %d, want %d", q.Limit, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureQueryMatchesPath(t *testing.T) {
+	capture := CapturedRoute{
+		Method:  "GET",
+		Host:    "api.example.com",
+		Path:    "/accounts/{id}",
+		FullURL: "https://api.example.com/accounts/42?expand=cards",
+		Status:  200,
+	}
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{"path=/accounts/*", true},
+		{"path=/accounts/42", true},
+		{"path=/cards/**", false},
+		{"method=get,post", true},
+		{"method=DELETE", false},
+		{"host=api.example.com", true},
+		{"status_min=400", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q, err := ParseCaptureQuery(values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.matches(capture); got != tt.match {
+				t.Errorf("got %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
+
+func TestQueryAllSkipsSavedCopies(t *testing.T) {
+	cp := NewCaptureProxy(t.TempDir())
+	// Anonymization rewrites URLs on save, so the copies can't be matched by URL
+	cp.anonymizer = newTestAnonymizer(t, AnonymizeConfig{})
+	start := time.Now()
+	for i, path := range []string{"/accounts/1", "/accounts/2"} {
+		cp.captures = append(cp.captures, CapturedRoute{
+			Method:     "GET",
+			Host:       "api.example.com",
+			Path:       "/accounts/{id}",
+			FullURL:    "https://api.example.com" + path + "?email=ada@example.com",
+			Status:     200,
+			CapturedAt: start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	unsaved := append([]CapturedRoute{}, cp.captures...)
+	if err := cp.SaveCaptures(); err != nil {
+		t.Fatal(err)
+	}
+	// As after a save that failed on all-captured.json: the per-service
+	// file is written but the captures are still in memory
+	cp.captures = unsaved
+
+	// One capture only in memory and one only in an older file
+	cp.captures = append(cp.captures, CapturedRoute{Method: "POST", Path: "/accounts", Status: 201, CapturedAt: start.Add(2 * time.Second)})
+	older, _ := json.Marshal(map[string][]CapturedRoute{"routes": {{Method: "DELETE", Path: "/accounts/{id}", Status: 204, CapturedAt: start.Add(-time.Hour)}}})
+	if err := os.WriteFile(filepath.Join(cp.outputDir, "older-captured.json"), older, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		source string
+		total  int
+		memory int
+	}{
+		{"memory", 3, 3},
+		{"files", 3, 0},
+		{"all", 4, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			q, err := ParseCaptureQuery(url.Values{"source": {tt.source}, "limit": {"2"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := cp.Query(q)
+			if result.Total != tt.total {
+				t.Errorf("total = %d, want %d", result.Total, tt.total)
+			}
+			if len(result.Results) != 2 {
+				t.Errorf("page has %d results, want 2", len(result.Results))
+			}
+
+			q.Limit = maxQueryLimit
+			memory := 0
+			for _, match := range cp.Query(q).Results {
+				if match.Source == "memory" {
+					memory++
+				}
+			}
+			if memory != tt.memory {
+				t.Errorf("%d results from memory, want %d", memory, tt.memory)
+			}
+		})
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"firecracker/mock-api-server/internal/resourceid"
+)
+
+// runResources implements the "resources" command: it infers resource
+// routes from capture files and writes them as a mock server routes file.
+// With -all the captures the resources don't serve are included too, so the
+// output can replace the capture files in CONFIG_PATH.
+func runResources(args []string) error {
+	fs := flag.NewFlagSet("resources", flag.ExitOnError)
+	outPath := fs.String("out", "", "file to write the routes to (default stdout)")
+	all := fs.Bool("all", false, "also include the captures not served by a resource")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: resources [-out configs/resources.json] [-all] captured/all-captured.json ...")
+	}
+
+	var captures []CapturedRoute
+	for _, file := range fs.Args() {
+		routes, err := loadCaptureFile(file)
+		if err != nil {
+			return err
+		}
+		captures = append(captures, routes...)
+	}
+
+	data, err := json.MarshalIndent(resourceRoutes(captures, *all), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if *outPath == "" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+	if err := os.WriteFile(*outPath, data, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Inferred %d resources from %d captures into %s", len(InferResources(captures)), len(captures), *outPath)
+	return nil
+}
+
+// resourceRoutes builds a routes file from the resources inferred from
+// captures, followed with all by the captures they don't serve.
+func resourceRoutes(captures []CapturedRoute, all bool) map[string]interface{} {
+	definitions := InferResources(captures)
+	routes := make([]interface{}, 0, len(definitions))
+	for _, definition := range definitions {
+		routes = append(routes, definition)
+	}
+	if all {
+		for _, capture := range captures {
+			if capture.Method != http.MethodConnect && !coveredByResource(capture, definitions) {
+				routes = append(routes, capture)
+			}
+		}
+	}
+	return map[string]interface{}{"routes": routes}
+}
+
+// ResourceDefinition is a mock server resource route: a collection whose
+// items were seen in the captures, so the mock can serve it statefully.
+type ResourceDefinition struct {
+	Path        string       `json:"path"`
+	Description string       `json:"description"`
+	Resource    ResourceSpec `json:"resource"`
+}
+
+// ResourceSpec mirrors the mock server's "resource" block.
+type ResourceSpec struct {
+	Items    []map[string]interface{} `json:"items"`
+	IDField  string                   `json:"id_field,omitempty"`
+	IDType   string                   `json:"id_type,omitempty"`
+	IDPrefix string                   `json:"id_prefix,omitempty"`
+	ListKey  string                   `json:"list_key,omitempty"`
+}
+
+// listKeys are the usual names of the array in a wrapped list response,
+// used when the response has more than one array of objects.
+var listKeys = []string{"data", "items", "results", "records", "content"}
+
+// idPrefixPattern finds type prefixes on string IDs such as "acc_" or "cus-".
+var idPrefixPattern = regexp.MustCompile(`^[A-Za-z]+[_-]`)
+
+// observedItem is an item seen in a response, with the values of the
+// collection path's placeholders in the request it came from.
+type observedItem struct {
+	item   map[string]interface{}
+	parent []string
+	pathID string // the item path's ID, for item endpoints
+}
+
+// collectionCaptures gathers what was seen of one collection.
+type collectionCaptures struct {
+	template string // normalized collection path, e.g. /accounts/{id}/cards
+	listKey  string
+	hasList  bool
+	hasItem  bool
+	captures int
+	// Observations in capture order; a nil item records a delete
+	observed []observedItem
+	host     string
+}
+
+// InferResources finds collections in captures: GET responses that are
+// arrays of objects (possibly wrapped, e.g. {"data": [...]}) at a path, and
+// object responses at that path plus an ID segment. Items seen in lists,
+// item reads and creates are merged by ID, and deletes remove them, so the
+// seeds reflect the state at the end of the session.
+func InferResources(captures []CapturedRoute) []ResourceDefinition {
+	ordered := make([]CapturedRoute, len(captures))
+	copy(ordered, captures)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].CapturedAt.Before(ordered[j].CapturedAt) })
+
+	collections := make(map[string]*collectionCaptures)
+	var order []string
+	collection := func(template, host string) *collectionCaptures {
+		c, ok := collections[template]
+		if !ok {
+			c = &collectionCaptures{template: template, host: host}
+			collections[template] = c
+			order = append(order, template)
+		}
+		return c
+	}
+
+	for _, capture := range ordered {
+		if capture.Method == http.MethodConnect || capture.GRPC != nil || capture.GraphQL != nil {
+			continue
+		}
+		if capture.Status < 200 || capture.Status >= 300 {
+			continue
+		}
+		template, _ := openAPIPath(capture.Path)
+		concrete := capture.Path
+		if u, err := url.Parse(capture.FullURL); err == nil && u.Path != "" {
+			concrete = u.Path
+		}
+		templateParts := strings.Split(strings.TrimSuffix(template, "/"), "/")
+		concreteParts := strings.Split(strings.TrimSuffix(concrete, "/"), "/")
+		if len(templateParts) != len(concreteParts) || len(templateParts) < 2 {
+			continue
+		}
+
+		last := len(templateParts) - 1
+		if templateParam.MatchString(templateParts[last]) {
+			// Item endpoint: the collection is the parent path
+			parentTemplate := strings.Join(templateParts[:last], "/")
+			if parentTemplate == "" || templateParam.MatchString(templateParts[last-1]) {
+				continue
+			}
+			parent := placeholderValues(templateParts[:last], concreteParts[:last])
+			pathID := concreteParts[last]
+			switch capture.Method {
+			case http.MethodGet, http.MethodPut, http.MethodPatch:
+				item, ok := capture.Response.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				c := collection(parentTemplate, capture.Host)
+				c.hasItem = true
+				c.captures++
+				c.observed = append(c.observed, observedItem{item: item, parent: parent, pathID: pathID})
+			case http.MethodDelete:
+				c := collection(parentTemplate, capture.Host)
+				c.captures++
+				c.observed = append(c.observed, observedItem{parent: parent, pathID: pathID})
+			}
+			continue
+		}
+
+		parent := placeholderValues(templateParts, concreteParts)
+		switch capture.Method {
+		case http.MethodGet:
+			items, listKey, ok := listItems(capture.Response)
+			if !ok {
+				continue
+			}
+			c := collection(template, capture.Host)
+			c.hasList = true
+			c.captures++
+			if listKey != "" {
+				c.listKey = listKey
+			}
+			for _, item := range items {
+				c.observed = append(c.observed, observedItem{item: item, parent: parent})
+			}
+		case http.MethodPost:
+			// Only kept if the path turns out to be a collection
+			if item, ok := capture.Response.(map[string]interface{}); ok {
+				c := collection(template, capture.Host)
+				c.captures++
+				c.observed = append(c.observed, observedItem{item: item, parent: parent})
+			}
+		}
+	}
+
+	var definitions []ResourceDefinition
+	for _, template := range order {
+		if definition, ok := collections[template].define(); ok {
+			definitions = append(definitions, definition)
+		}
+	}
+	return definitions
+}
+
+// placeholderValues returns the concrete values of the template's
+// placeholders.
+func placeholderValues(templateParts, concreteParts []string) []string {
+	var values []string
+	for i, part := range templateParts {
+		if templateParam.MatchString(part) {
+			values = append(values, concreteParts[i])
+		}
+	}
+	return values
+}
+
+// listItems recognizes a list response: an array of objects, or an object
+// with one (or a conventionally named) array of objects.
+func listItems(response interface{}) ([]map[string]interface{}, string, bool) {
+	objects := func(value interface{}) ([]map[string]interface{}, bool) {
+		array, ok := value.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		items := make([]map[string]interface{}, 0, len(array))
+		for _, element := range array {
+			item, ok := element.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			items = append(items, item)
+		}
+		return items, true
+	}
+
+	if items, ok := objects(response); ok {
+		return items, "", true
+	}
+	wrapper, ok := response.(map[string]interface{})
+	if !ok {
+		return nil, "", false
+	}
+	for _, key := range listKeys {
+		if items, ok := objects(wrapper[key]); ok {
+			return items, key, true
+		}
+	}
+	var found []string
+	for key, value := range wrapper {
+		if items, ok := objects(value); ok && len(items) > 0 {
+			found = append(found, key)
+		}
+	}
+	if len(found) != 1 {
+		return nil, "", false
+	}
+	items, _ := objects(wrapper[found[0]])
+	return items, found[0], true
+}
+
+// define turns the observations into a resource, or reports false when no
+// ID field identifies the items.
+func (c *collectionCaptures) define() (ResourceDefinition, bool) {
+	if !c.hasList && !c.hasItem {
+		return ResourceDefinition{}, false
+	}
+	idField := c.idField()
+	if idField == "" {
+		return ResourceDefinition{}, false
+	}
+
+	// Name the parent placeholders after the item field holding their
+	// value, so the mock scopes nested collections by it
+	segments := strings.Split(c.template, "/")
+	var paramIndexes []int
+	for i, segment := range segments {
+		if templateParam.MatchString(segment) {
+			paramIndexes = append(paramIndexes, i)
+		}
+	}
+	paramFields := make([]string, len(paramIndexes))
+	inject := make([]bool, len(paramIndexes))
+	for k, i := range paramIndexes {
+		paramFields[k] = c.parentField(k, idField)
+		if paramFields[k] == "" {
+			paramFields[k] = singular(segments[i-1]) + "_id"
+			inject[k] = true
+		}
+		segments[i] = "{" + paramFields[k] + "}"
+	}
+
+	var items []map[string]interface{}
+	index := make(map[string]int)
+	for _, observed := range c.observed {
+		id := observed.pathID
+		if observed.item != nil {
+			if value, ok := observed.item[idField]; ok {
+				id = resourceid.Format(value)
+			}
+		}
+		if id == "" {
+			continue
+		}
+		key := strings.Join(observed.parent, "/") + "/" + id
+
+		if observed.item == nil {
+			if i, ok := index[key]; ok {
+				items[i] = nil
+				delete(index, key)
+			}
+			continue
+		}
+
+		item := make(map[string]interface{}, len(observed.item)+len(paramFields))
+		for name, value := range observed.item {
+			item[name] = value
+		}
+		for k, field := range paramFields {
+			if _, ok := item[field]; !ok && inject[k] {
+				item[field] = observed.parent[k]
+			}
+		}
+		if i, ok := index[key]; ok {
+			// Item reads usually carry more detail than list entries
+			for name, value := range item {
+				items[i][name] = value
+			}
+			continue
+		}
+		index[key] = len(items)
+		items = append(items, item)
+	}
+
+	seeds := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		if item != nil {
+			seeds = append(seeds, item)
+		}
+	}
+
+	spec := ResourceSpec{Items: seeds, IDField: idField, IDType: "uuid", ListKey: c.listKey}
+	if idField == "id" {
+		spec.IDField = ""
+	}
+	numeric, prefix := true, ""
+	for i, item := range seeds {
+		switch id := item[idField].(type) {
+		case float64:
+		case string:
+			numeric = false
+			if i == 0 {
+				prefix = idPrefixPattern.FindString(id)
+			} else if !strings.HasPrefix(id, prefix) {
+				prefix = ""
+			}
+		default:
+			numeric = false
+		}
+	}
+	if numeric && len(seeds) > 0 {
+		spec.IDType = "int"
+	} else {
+		spec.IDPrefix = prefix
+	}
+
+	return ResourceDefinition{
+		Path:        strings.Join(segments, "/"),
+		Description: fmt.Sprintf("Resource inferred from %d captures of %s%s", c.captures, c.host, c.template),
+		Resource:    spec,
+	}, true
+}
+
+// idField finds the field identifying items: the one the item endpoint's
+// path IDs match, or without item reads a conventional ID field present and
+// unique in every list item.
+func (c *collectionCaptures) idField() string {
+	counts := make(map[string]int)
+	itemReads := 0
+	for _, observed := range c.observed {
+		if observed.item == nil || observed.pathID == "" {
+			continue
+		}
+		itemReads++
+		for name, value := range observed.item {
+			if resourceid.Format(value) == observed.pathID {
+				counts[name]++
+			}
+		}
+	}
+	if itemReads > 0 {
+		if counts["id"] == itemReads {
+			return "id"
+		}
+		var names []string
+		for name, count := range counts {
+			if count == itemReads {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			return ""
+		}
+		return names[0]
+	}
+
+	segments := strings.Split(c.template, "/")
+	name := singular(segments[len(segments)-1])
+	candidates := []string{"id", name + "_id", name + "Id", "uuid", "_id", "key"}
+	for _, candidate := range candidates {
+		seen := make(map[string]bool)
+		unique := true
+		for _, observed := range c.observed {
+			if observed.item == nil {
+				continue
+			}
+			value, ok := observed.item[candidate]
+			if !ok || !scalarID(value) {
+				unique = false
+				break
+			}
+			id := strings.Join(observed.parent, "/") + "/" + resourceid.Format(value)
+			if seen[id] {
+				unique = false
+				break
+			}
+			seen[id] = true
+		}
+		if unique && len(seen) > 0 {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// parentField finds the item field holding the value of the collection
+// path's k-th placeholder in every observation.
+func (c *collectionCaptures) parentField(k int, idField string) string {
+	var candidates map[string]bool
+	for _, observed := range c.observed {
+		if observed.item == nil {
+			continue
+		}
+		matching := make(map[string]bool)
+		for name, value := range observed.item {
+			if name != idField && resourceid.Format(value) == observed.parent[k] {
+				matching[name] = true
+			}
+		}
+		if candidates == nil {
+			candidates = matching
+			continue
+		}
+		for name := range candidates {
+			if !matching[name] {
+				delete(candidates, name)
+			}
+		}
+	}
+	names := make([]string, 0, len(candidates))
+	for name := range candidates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
+
+// scalarID reports whether value can be an ID: a string or a number.
+func scalarID(value interface{}) bool {
+	switch value.(type) {
+	case string, float64:
+		return true
+	}
+	return false
+}
+
+// singular makes a rough singular of a collection name for ID field names.
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies"):
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "sses"), strings.HasSuffix(name, "xes"):
+		return strings.TrimSuffix(name, "es")
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss"):
+		return strings.TrimSuffix(name, "s")
+	}
+	return name
+}
+
+// paramShape replaces the placeholders in a path with "{}", so paths
+// compare equal whatever their parameters are called.
+func paramShape(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if templateParam.MatchString(part) {
+			parts[i] = "{}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// coveredByResource reports whether one of the resources serves capture's
+// method and path.
+func coveredByResource(capture CapturedRoute, definitions []ResourceDefinition) bool {
+	shape := paramShape(capture.Path)
+	for _, definition := range definitions {
+		collection := paramShape(definition.Path)
+		switch {
+		case shape == collection:
+			if capture.Method == http.MethodGet || capture.Method == http.MethodPost {
+				return true
+			}
+		case shape == collection+"/{}":
+			switch capture.Method {
+			case http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete:
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"firecracker/mock-api-server/internal/resourceid"
+)
+
+// testCaptures builds captures from method, path, status and response JSON,
+// captured one second apart in the order given.
+func testCaptures(t *testing.T, exchanges [][4]string) []CapturedRoute {
+	t.Helper()
+	normalizer, err := NewPathNormalizer(NormalizeConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1700000000, 0)
+	captures := make([]CapturedRoute, len(exchanges))
+	for i, exchange := range exchanges {
+		method, path, status, response := exchange[0], exchange[1], exchange[2], exchange[3]
+		capture := CapturedRoute{
+			Method:     method,
+			Host:       "api.example.com",
+			Path:       normalizer.Normalize("api.example.com", path),
+			FullURL:    "https://api.example.com" + path,
+			CapturedAt: start.Add(time.Duration(i) * time.Second),
+		}
+		capture.Status, _ = strconv.Atoi(status)
+		if response != "" {
+			capture.Response = decodeJSON(t, response)
+		}
+		captures[i] = capture
+	}
+	return captures
+}
+
+// seedIDs lists the formatted IDs of a definition's seeds in order.
+func seedIDs(definition ResourceDefinition) []string {
+	field := definition.Resource.IDField
+	if field == "" {
+		field = "id"
+	}
+	var ids []string
+	for _, item := range definition.Resource.Items {
+		ids = append(ids, resourceid.Format(item[field]))
+	}
+	return ids
+}
+
+func TestInferResources(t *testing.T) {
+	tests := []struct {
+		name      string
+		exchanges [][4]string
+		path      string // "" when no resource should be inferred
+		ids       []string
+		idField   string
+		idType    string
+		idPrefix  string
+		listKey   string
+	}{
+		{
+			name: "list, read, create and delete",
+			exchanges: [][4]string{
+				{"GET", "/customers", "200", `[{"id": 1, "name": "Ada"}, {"id": 2, "name": "Grace"}]`},
+				{"GET", "/customers/1", "200", `{"id": 1, "name": "Ada", "email": "ada@example.com"}`},
+				{"POST", "/customers", "201", `{"id": 3, "name": "Linus"}`},
+				{"DELETE", "/customers/2", "204", ""},
+				{"GET", "/customers/9", "404", `{"error": "not found"}`},
+			},
+			path:   "/customers",
+			ids:    []string{"1", "3"},
+			idType: "int",
+		},
+		{
+			name: "wrapped list with prefixed string IDs",
+			exchanges: [][4]string{
+				{"GET", "/customers", "200", `{"data": [{"id": "cus_a1"}, {"id": "cus_b2"}], "has_more": false}`},
+			},
+			path:     "/customers",
+			ids:      []string{"cus_a1", "cus_b2"},
+			idType:   "uuid",
+			idPrefix: "cus_",
+			listKey:  "data",
+		},
+		{
+			name: "conventional ID field other than id",
+			exchanges: [][4]string{
+				{"GET", "/orders", "200", `[{"order_id": "o1"}, {"order_id": "o2"}]`},
+			},
+			path:    "/orders",
+			ids:     []string{"o1", "o2"},
+			idField: "order_id",
+			idType:  "uuid",
+		},
+		{
+			name: "ID field from item reads",
+			exchanges: [][4]string{
+				{"GET", "/accounts/ACC-1", "200", `{"number": "ACC-1", "balance": 10}`},
+				{"GET", "/accounts/ACC-2", "200", `{"number": "ACC-2", "balance": 20}`},
+			},
+			path:     "/accounts",
+			ids:      []string{"ACC-1", "ACC-2"},
+			idField:  "number",
+			idType:   "uuid",
+			idPrefix: "ACC-",
+		},
+		{
+			name: "nested collection scoped by an item field",
+			exchanges: [][4]string{
+				{"GET", "/accounts/123/cards", "200", `[{"id": 7, "account": "123"}]`},
+				{"GET", "/accounts/456/cards", "200", `[{"id": 7, "account": "456"}]`},
+			},
+			path:   "/accounts/{account}/cards",
+			ids:    []string{"7", "7"},
+			idType: "int",
+		},
+		{
+			name: "nested collection without a parent field",
+			exchanges: [][4]string{
+				{"GET", "/accounts/123/cards", "200", `[{"id": 7}]`},
+			},
+			path:   "/accounts/{account_id}/cards",
+			ids:    []string{"7"},
+			idType: "int",
+		},
+		{
+			name: "items without a unique ID",
+			exchanges: [][4]string{
+				{"GET", "/events", "200", `[{"type": "a"}, {"type": "b"}]`},
+			},
+		},
+		{
+			name: "duplicate IDs in a list",
+			exchanges: [][4]string{
+				{"GET", "/events", "200", `[{"id": 1}, {"id": 1}]`},
+			},
+		},
+		{
+			name: "scalar list",
+			exchanges: [][4]string{
+				{"GET", "/tags", "200", `["a", "b"]`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			definitions := InferResources(testCaptures(t, tt.exchanges))
+			if tt.path == "" {
+				if len(definitions) != 0 {
+					t.Fatalf("inferred %+v, want nothing", definitions)
+				}
+				return
+			}
+			if len(definitions) != 1 {
+				t.Fatalf("inferred %d resources, want 1: %+v", len(definitions), definitions)
+			}
+			definition := definitions[0]
+			if definition.Path != tt.path {
+				t.Errorf("path = %q, want %q", definition.Path, tt.path)
+			}
+			spec := definition.Resource
+			if spec.IDField != tt.idField || spec.IDType != tt.idType || spec.IDPrefix != tt.idPrefix || spec.ListKey != tt.listKey {
+				t.Errorf("spec = id_field %q id_type %q id_prefix %q list_key %q, want %q %q %q %q",
+					spec.IDField, spec.IDType, spec.IDPrefix, spec.ListKey, tt.idField, tt.idType, tt.idPrefix, tt.listKey)
+			}
+			ids := seedIDs(definition)
+			if len(ids) != len(tt.ids) {
+				t.Fatalf("seed ids = %v, want %v", ids, tt.ids)
+			}
+			for i := range ids {
+				if ids[i] != tt.ids[i] {
+					t.Errorf("seed ids = %v, want %v", ids, tt.ids)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestInferResourcesMergesItemReads(t *testing.T) {
+	definitions := InferResources(testCaptures(t, [][4]string{
+		{"GET", "/customers", "200", `[{"id": 1, "name": "Ada"}]`},
+		{"GET", "/customers/1", "200", `{"id": 1, "name": "Ada L", "email": "ada@example.com"}`},
+	}))
+	if len(definitions) != 1 || len(definitions[0].Resource.Items) != 1 {
+		t.Fatalf("inferred %+v, want one resource with one item", definitions)
+	}
+	item := definitions[0].Resource.Items[0]
+	if item["name"] != "Ada L" || item["email"] != "ada@example.com" {
+		t.Errorf("item = %v, want the item read's fields", item)
+	}
+}
+
+func TestSingular(t *testing.T) {
+	tests := map[string]string{
+		"customers":  "customer",
+		"companies":  "company",
+		"addresses":  "address",
+		"boxes":      "box",
+		"access":     "access",
+		"people":     "people",
+		"categories": "category",
+	}
+	for name, want := range tests {
+		if got := singular(name); got != want {
+			t.Errorf("singular(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCoveredByResource(t *testing.T) {
+	definitions := []ResourceDefinition{{Path: "/accounts/{account_id}/cards"}}
+	tests := []struct {
+		method  string
+		path    string
+		covered bool
+	}{
+		{"GET", "/accounts/{id}/cards", true},
+		{"POST", "/accounts/{id}/cards", true},
+		{"DELETE", "/accounts/{id}/cards", false},
+		{"PATCH", "/accounts/{id}/cards/{id}", true},
+		{"POST", "/accounts/{id}/cards/{id}", false},
+		{"GET", "/accounts/{id}", false},
+	}
+	for _, tt := range tests {
+		capture := CapturedRoute{Method: tt.method, Path: tt.path}
+		if got := coveredByResource(capture, definitions); got != tt.covered {
+			t.Errorf("%s %s covered = %v, want %v", tt.method, tt.path, got, tt.covered)
+		}
+	}
+}
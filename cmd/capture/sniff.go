@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// sniffTimeout bounds how long a raw connection waits for the client to
+// speak first; server-first protocols are relayed without inspection once it
+// expires, so their greeting is delayed by this much on every connection.
+const sniffTimeout = 2 * time.Second
+
+var httpMethodPrefixes = [][]byte{
+	[]byte("GET "), []byte("POST "), []byte("PUT "), []byte("PATCH "), []byte("DELETE "),
+	[]byte("HEAD "), []byte("OPTIONS "), []byte("TRACE "),
+}
+
+// sniffHTTP peeks at the first bytes a client sends and reports whether
+// they start a plain HTTP request.
+func sniffHTTP(conn net.Conn, reader *bufio.Reader) bool {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	peek, _ := reader.Peek(8)
+	conn.SetReadDeadline(time.Time{})
+
+	for _, prefix := range httpMethodPrefixes {
+		if bytes.HasPrefix(peek, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sniffH2C reports whether the client opened with the HTTP/2 connection
+// preface, as plaintext gRPC clients do. Call it after sniffHTTP, which has
+// already waited for the client to speak.
+func sniffH2C(conn net.Conn, reader *bufio.Reader) bool {
+	if reader.Buffered() == 0 {
+		return false
+	}
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	if peek, _ := reader.Peek(4); !bytes.Equal(peek, []byte("PRI ")) {
+		return false
+	}
+	peek, _ := reader.Peek(len(http2.ClientPreface))
+	return string(peek) == http2.ClientPreface
+}
+
+// sniffTLS reports whether the client opened with a TLS handshake record.
+func sniffTLS(conn net.Conn, reader *bufio.Reader) bool {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	peek, _ := reader.Peek(1)
+	conn.SetReadDeadline(time.Time{})
+	return len(peek) == 1 && peek[0] == 0x16
+}
+
+var errHelloRead = errors.New("client hello read")
+
+// sniffSNI returns the server name from a TLS ClientHello waiting in reader,
+// without consuming it. It returns "" for anything else.
+func sniffSNI(conn net.Conn, reader *bufio.Reader) string {
+	conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	header, err := reader.Peek(5)
+	if err != nil || header[0] != 0x16 {
+		return ""
+	}
+	record, err := reader.Peek(5 + int(binary.BigEndian.Uint16(header[3:5])))
+	if err != nil {
+		return ""
+	}
+
+	// Let crypto/tls parse the hello, then abort before it answers
+	var serverName string
+	tls.Server(helloConn{bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errHelloRead
+		},
+	}).Handshake()
+	return serverName
+}
+
+// helloConn is a read-only net.Conn over a buffered ClientHello.
+type helloConn struct {
+	reader io.Reader
+}
+
+func (c helloConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c helloConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c helloConn) Close() error                       { return nil }
+func (c helloConn) LocalAddr() net.Addr                { return nil }
+func (c helloConn) RemoteAddr() net.Addr               { return nil }
+func (c helloConn) SetDeadline(t time.Time) error      { return nil }
+func (c helloConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c helloConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// proxyHandler forwards requests read off a raw or decrypted connection.
+// Requests without a Host header go to defaultHost.
+func (cp *CaptureProxy) proxyHandler(scheme, defaultHost string, pin bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if host == "" {
+			host = defaultHost
+		}
+		r.URL.Scheme = scheme
+		r.URL.Host = host
+		if pin {
+			// The Host header still names the virtual host (see forward)
+			r.URL.Host = defaultHost
+		}
+		log.Printf("Received request: %s %s (%s)", r.Method, r.URL.String(), r.Proto)
+		cp.forward(w, r, r.URL.String(), host)
+	})
+}
+
+// servePlaintext captures cleartext HTTP/1.x, or HTTP/2 with prior
+// knowledge, arriving on a raw connection to host. With pin the requests go
+// to host whatever their Host header says; otherwise host is only used when
+// they have none. It reports false, having consumed nothing, when the client
+// speaks something else.
+func (cp *CaptureProxy) servePlaintext(client *bufferedConn, host string, pin bool) bool {
+	serve := cp.plaintextServer(client, host, pin)
+	if serve == nil {
+		return false
+	}
+	serve()
+	return true
+}
+
+// plaintextServer sniffs client as servePlaintext does and returns the
+// function that serves it, or nil when the client speaks something else.
+func (cp *CaptureProxy) plaintextServer(client *bufferedConn, host string, pin bool) func() {
+	handler := cp.proxyHandler("http", host, pin)
+	switch {
+	case sniffHTTP(client.Conn, client.reader):
+		return func() { serveConn(client, handler) }
+	case sniffH2C(client.Conn, client.reader):
+		return func() { (&http2.Server{}).ServeConn(client, &http2.ServeConnOpts{Handler: handler}) }
+	}
+	return nil
+}
+
+// serveConn serves the HTTP/1.x requests on a single connection.
+func serveConn(conn net.Conn, handler http.Handler) {
+	listener := newSingleConnListener(conn)
+	server := &http.Server{
+		Handler: handler,
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateClosed || state == http.StateHijacked {
+				listener.Close()
+			}
+		},
+	}
+	server.Serve(listener)
+}
+
+// tunnelConn relays traffic that cannot be inspected to addr, recording it
+// under host the same way as a CONNECT tunnel.
+func (cp *CaptureProxy) tunnelConn(client net.Conn, addr, host string) {
+	ticket, err := cp.guard.acquire(addr)
+	if err != nil {
+		log.Printf("⛔ Tunnel to %s refused: %v", addr, err)
+		return
+	}
+	// The tunnel holds its concurrency slot until it closes
+	defer ticket.release()
+	target, err := cp.dialTunnel(addr)
+	ticket.result(err != nil, err)
+	if err != nil {
+		log.Printf("Error connecting to %s: %v", addr, err)
+		return
+	}
+	defer target.Close()
+
+	cp.recordTunnel(host, http.Header{})
+	log.Printf("✅ Tunnel established to %s (content not captured)", host)
+
+	relay(client, target)
+}
+
+// relay copies between client and target until either side is done, then
+// closes both so the other direction (and the tunnel's slot) ends too.
+func relay(client, target net.Conn) {
+	go func() {
+		io.Copy(target, client)
+		target.Close()
+	}()
+	io.Copy(client, target)
+	client.Close()
+}
+
+// singleConnListener hands one connection to an http.Server and reports
+// closed once that connection is done.
+type singleConnListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+	taken  bool
+	mu     sync.Mutex
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	return &singleConnListener{conn: conn, closed: make(chan struct{})}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	if !l.taken {
+		l.taken = true
+		l.mu.Unlock()
+		return l.conn, nil
+	}
+	l.mu.Unlock()
+
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *singleConnListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
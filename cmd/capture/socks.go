@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+)
+
+const (
+	socksVersion       = 0x05
+	socksAuthNone      = 0x00
+	socksAuthPassword  = 0x02
+	socksNoAcceptable  = 0xff
+	socksCmdConnect    = 0x01
+	socksAtypIPv4      = 0x01
+	socksAtypDomain    = 0x03
+	socksAtypIPv6      = 0x04
+	socksReplySuccess  = 0x00
+	socksReplyFailure  = 0x01
+	socksReplyNotAllow = 0x07
+	socksReplyAtyp     = 0x08
+)
+
+// SOCKSServer is a SOCKS5 front end for a CaptureProxy. Connections that
+// turn out to carry plain HTTP (or h2c) go through the same capture pipeline as proxy
+// requests; TLS and anything else is tunnelled and recorded like CONNECT.
+type SOCKSServer struct {
+	proxy    *CaptureProxy
+	username string
+	password string
+}
+
+// NewSOCKSServer returns a SOCKS5 server for cp. When username is set,
+// clients must authenticate with username/password (RFC 1929).
+func NewSOCKSServer(cp *CaptureProxy, username, password string) *SOCKSServer {
+	return &SOCKSServer{proxy: cp, username: username, password: password}
+}
+
+func (s *SOCKSServer) ListenAndServe(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *SOCKSServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	addr, err := s.handshake(conn, reader)
+	if err != nil {
+		log.Printf("SOCKS handshake from %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+	log.Printf("🧦 SOCKS connection to %s", addr)
+
+	// Requests go where the client asked the SOCKS server to connect, not
+	// wherever their Host header points
+	client := &bufferedConn{Conn: conn, reader: reader}
+	switch {
+	case s.proxy.servePlaintext(client, addr, true):
+	case s.proxy.mitm != nil:
+		s.proxy.interceptTLS(client, addr, true)
+	default:
+		s.proxy.tunnelConn(client, addr, addr)
+	}
+}
+
+// handshake negotiates authentication and reads a CONNECT request,
+// answering it before the client's first bytes are sniffed.
+func (s *SOCKSServer) handshake(conn net.Conn, reader *bufio.Reader) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socksAuthNone)
+	if s.username != "" {
+		method = socksAuthPassword
+	}
+	if bytes.IndexByte(methods, method) < 0 {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return "", errors.New("no acceptable authentication method")
+	}
+	conn.Write([]byte{socksVersion, method})
+
+	if method == socksAuthPassword {
+		if err := s.authenticate(conn, reader); err != nil {
+			return "", err
+		}
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(reader, request); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d in request", request[0])
+	}
+	if request[1] != socksCmdConnect {
+		s.reply(conn, socksReplyNotAllow)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAtypIPv4, socksAtypIPv6:
+		ip := make([]byte, net.IPv4len)
+		if request[3] == socksAtypIPv6 {
+			ip = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(reader, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		name := make([]byte, length)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		s.reply(conn, socksReplyAtyp)
+		return "", fmt.Errorf("unsupported address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(reader, port); err != nil {
+		return "", err
+	}
+
+	s.reply(conn, socksReplySuccess)
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+func (s *SOCKSServer) authenticate(conn net.Conn, reader *bufio.Reader) error {
+	read := func() (string, error) {
+		length, err := reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		value := make([]byte, length)
+		_, err = io.ReadFull(reader, value)
+		return string(value), err
+	}
+
+	if _, err := reader.ReadByte(); err != nil { // subnegotiation version
+		return err
+	}
+	username, err := read()
+	if err != nil {
+		return err
+	}
+	password, err := read()
+	if err != nil {
+		return err
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(username), []byte(s.username))
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(s.password))
+	if userOK&passwordOK != 1 {
+		conn.Write([]byte{0x01, socksReplyFailure})
+		return fmt.Errorf("authentication failed for %q", username)
+	}
+	conn.Write([]byte{0x01, socksReplySuccess})
+	return nil
+}
+
+// reply answers a request; the bound address is not meaningful to clients
+// of a capture proxy, so it is always 0.0.0.0:0.
+func (s *SOCKSServer) reply(conn net.Conn, code byte) {
+	conn.Write([]byte{socksVersion, code, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+}
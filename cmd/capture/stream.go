@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// streamHistory is how many recent events a client can resume from.
+	streamHistory = 1000
+	// streamBuffer is how far a subscriber may fall behind before it is
+	// disconnected; it can reconnect and resume from its last sequence ID.
+	streamBuffer    = 256
+	streamHeartbeat = 15 * time.Second
+)
+
+// CaptureEvent is one stored capture as sent to live stream clients. Seq
+// increases by one per capture for the lifetime of the proxy.
+type CaptureEvent struct {
+	Seq     uint64        `json:"seq"`
+	Capture CapturedRoute `json:"capture"`
+}
+
+// captureStream fans new captures out to live subscribers and keeps a short
+// history for clients that reconnect.
+type captureStream struct {
+	mu          sync.Mutex
+	seq         uint64
+	history     []CaptureEvent
+	subscribers map[chan CaptureEvent]struct{}
+}
+
+func newCaptureStream() *captureStream {
+	return &captureStream{subscribers: make(map[chan CaptureEvent]struct{})}
+}
+
+func (s *captureStream) Publish(capture CapturedRoute) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	event := CaptureEvent{Seq: s.seq, Capture: capture}
+	s.history = append(s.history, event)
+	if len(s.history) > streamHistory {
+		s.history = s.history[len(s.history)-streamHistory:]
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Seq returns the sequence ID of the latest capture.
+func (s *captureStream) Seq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
+
+// Subscribe returns the retained events after since and a channel for the
+// ones that follow. The channel is closed if the subscriber falls behind.
+func (s *captureStream) Subscribe(since uint64) ([]CaptureEvent, chan CaptureEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var backlog []CaptureEvent
+	for _, event := range s.history {
+		if event.Seq > since {
+			backlog = append(backlog, event)
+		}
+	}
+
+	ch := make(chan CaptureEvent, streamBuffer)
+	s.subscribers[ch] = struct{}{}
+	return backlog, ch
+}
+
+func (s *captureStream) Unsubscribe(ch chan CaptureEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.subscribers[ch]; ok {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+// streamRequest holds the resume point and filter parsed from a stream
+// request. Filters use the same fields as FilterRule: host, path, method,
+// status and content_type, with comma-separated lists.
+type streamRequest struct {
+	since  uint64
+	filter FilterRule
+}
+
+func parseStreamRequest(r *http.Request) (*streamRequest, error) {
+	query := r.URL.Query()
+	req := &streamRequest{}
+
+	// EventSource reconnects to the same URL, so ?since= is still the load
+	// time sequence while Last-Event-ID is the last event it got; resume
+	// from whichever is later
+	for _, since := range []string{query.Get("since"), r.Header.Get("Last-Event-ID")} {
+		if since == "" {
+			continue
+		}
+		seq, err := strconv.ParseUint(since, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid since %q", since)
+		}
+		if seq > req.since {
+			req.since = seq
+		}
+	}
+
+	list := func(name string) []string {
+		var values []string
+		for _, value := range query[name] {
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					values = append(values, v)
+				}
+			}
+		}
+		return values
+	}
+
+	filter, err := NewCaptureFilter(FilterConfig{Include: []FilterRule{{
+		Host:        query.Get("host"),
+		Path:        query.Get("path"),
+		Methods:     list("method"),
+		Status:      list("status"),
+		ContentType: query.Get("content_type"),
+	}}})
+	if err != nil {
+		return nil, err
+	}
+	req.filter = filter.config.Include[0]
+	return req, nil
+}
+
+// handleStream serves /capture/stream: Server-Sent Events by default, or a
+// WebSocket when the client asks to upgrade. Both send CaptureEvent JSON,
+// starting with retained events after ?since= or Last-Event-ID, whichever
+// is later.
+func (cp *CaptureProxy) handleStream(w http.ResponseWriter, r *http.Request) {
+	req, err := parseStreamRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			cp.streamWebSocket(ws, req)
+		}}
+		server.ServeHTTP(w, r)
+		return
+	}
+	cp.streamSSE(w, r, req)
+}
+
+func (cp *CaptureProxy) streamSSE(w http.ResponseWriter, r *http.Request, req *streamRequest) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	backlog, events := cp.stream.Subscribe(req.since)
+	defer cp.stream.Unsubscribe(events)
+
+	send := func(event CaptureEvent) error {
+		if !req.filter.matches(event.Capture) {
+			return nil
+		}
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: capture\ndata: %s\n\n", event.Seq, data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	for _, event := range backlog {
+		if send(event) != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if send(event) != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (cp *CaptureProxy) streamWebSocket(ws *websocket.Conn, req *streamRequest) {
+	defer ws.Close()
+
+	backlog, events := cp.stream.Subscribe(req.since)
+	defer cp.stream.Unsubscribe(events)
+
+	// Clients don't send anything; reading only detects when they go away
+	closed := make(chan struct{})
+	go func() {
+		var discard string
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+		close(closed)
+	}()
+
+	send := func(event CaptureEvent) error {
+		if !req.filter.matches(event.Capture) {
+			return nil
+		}
+		return websocket.JSON.Send(ws, event)
+	}
+
+	for _, event := range backlog {
+		if send(event) != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if err := send(event); err != nil {
+				log.Printf("Capture stream client disconnected: %v", err)
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func publishN(s *captureStream, n int) {
+	for i := 0; i < n; i++ {
+		s.Publish(CapturedRoute{Method: "GET", Path: "/items"})
+	}
+}
+
+func eventSeqs(events []CaptureEvent) []uint64 {
+	seqs := make([]uint64, len(events))
+	for i, event := range events {
+		seqs[i] = event.Seq
+	}
+	return seqs
+}
+
+func TestCaptureStreamSubscribeResumes(t *testing.T) {
+	tests := []struct {
+		name      string
+		published int
+		since     uint64
+		first     uint64 // first backlog seq, 0 for none
+		backlog   int
+	}{
+		{"from the start", 3, 0, 1, 3},
+		{"after a seen event", 3, 2, 3, 1},
+		{"up to date", 3, 3, 0, 0},
+		{"ahead of the stream", 3, 10, 0, 0},
+		{"history is trimmed", streamHistory + 5, 0, 6, streamHistory},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCaptureStream()
+			publishN(s, tt.published)
+			backlog, ch := s.Subscribe(tt.since)
+			defer s.Unsubscribe(ch)
+
+			if len(backlog) != tt.backlog {
+				t.Fatalf("backlog has %d events, want %d", len(backlog), tt.backlog)
+			}
+			if tt.backlog > 0 && backlog[0].Seq != tt.first {
+				t.Errorf("backlog starts at %d, want %d", backlog[0].Seq, tt.first)
+			}
+			for i := 1; i < len(backlog); i++ {
+				if backlog[i].Seq != backlog[i-1].Seq+1 {
+					t.Fatalf("backlog not contiguous: %v", eventSeqs(backlog))
+				}
+			}
+
+			s.Publish(CapturedRoute{Method: "POST"})
+			if event := <-ch; event.Seq != uint64(tt.published)+1 || event.Capture.Method != "POST" {
+				t.Errorf("live event = %+v, want seq %d", event, tt.published+1)
+			}
+		})
+	}
+}
+
+func TestCaptureStreamDropsSlowSubscribers(t *testing.T) {
+	s := newCaptureStream()
+	_, slow := s.Subscribe(0)
+	_, fast := s.Subscribe(0)
+
+	for i := 0; i < streamBuffer+1; i++ {
+		s.Publish(CapturedRoute{})
+		<-fast
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != streamBuffer {
+		t.Errorf("slow subscriber got %d events before being dropped, want %d", received, streamBuffer)
+	}
+	s.Unsubscribe(slow) // already dropped; must not close twice
+
+	// The dropped subscriber resumes from its last event without a gap
+	backlog, ch := s.Subscribe(uint64(received))
+	defer s.Unsubscribe(ch)
+	if seqs := eventSeqs(backlog); len(seqs) != 1 || seqs[0] != streamBuffer+1 {
+		t.Errorf("resumed backlog = %v, want [%d]", seqs, streamBuffer+1)
+	}
+}
+
+func TestParseStreamRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+		since       uint64
+		wantErr     bool
+	}{
+		{"nothing", "", "", 0, false},
+		{"since", "?since=5", "", 5, false},
+		{"Last-Event-ID", "", "7", 7, false},
+		{"reconnect after the load seq", "?since=5", "9", 9, false},
+		{"later since wins", "?since=12", "9", 12, false},
+		{"invalid since", "?since=x", "", 0, true},
+		{"invalid Last-Event-ID", "?since=5", "x", 0, true},
+		{"invalid filter", "?path=[", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/capture/stream"+tt.query, nil)
+			if tt.lastEventID != "" {
+				r.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			req, err := parseStreamRequest(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && req.since != tt.since {
+				t.Errorf("since = %d, want %d", req.since, tt.since)
+			}
+		})
+	}
+}
+
+func TestStreamRequestFilter(t *testing.T) {
+	capture := CapturedRoute{
+		Method:          "POST",
+		Host:            "api.example.com",
+		Path:            "/accounts/{id}",
+		FullURL:         "https://api.example.com/accounts/42",
+		Status:          201,
+		ResponseHeaders: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+	}
+	tests := []struct {
+		query string
+		match bool
+	}{
+		{"", true},
+		{"?method=get,post", true},
+		{"?method=GET", false},
+		{"?host=*.example.com", true},
+		{"?host=other.com", false},
+		{"?status=2xx", true},
+		{"?status=404,500", false},
+		{"?content_type=application/*", true},
+		{"?content_type=text/html", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req, err := parseStreamRequest(httptest.NewRequest("GET", "/capture/stream"+tt.query, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := req.filter.matches(capture); got != tt.match {
+				t.Errorf("matches = %v, want %v", got, tt.match)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"net"
+)
+
+// TransparentListener accepts connections redirected to it by iptables, so
+// clients need no proxy configuration. The original destination comes from
+// SO_ORIGINAL_DST (REDIRECT/DNAT) or the socket's local address (TPROXY);
+// when neither is available the HTTP Host header or TLS SNI is used.
+// Server-first protocols are only relayed after sniffTimeout.
+//
+// Example rules, with the proxy running as a user other than the app:
+//
+//	iptables -t nat -A OUTPUT -p tcp -m owner ! --uid-owner proxy \
+//	  -m multiport --dports 80,443 -j REDIRECT --to-ports 8092
+type TransparentListener struct {
+	proxy  *CaptureProxy
+	tproxy bool
+}
+
+func NewTransparentListener(cp *CaptureProxy, tproxy bool) *TransparentListener {
+	return &TransparentListener{proxy: cp, tproxy: tproxy}
+}
+
+func (t *TransparentListener) ListenAndServe(addr string) error {
+	var config net.ListenConfig
+	if t.tproxy {
+		config.Control = tproxyControl
+	}
+	listener, err := config.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go t.handle(conn)
+	}
+}
+
+func (t *TransparentListener) handle(conn net.Conn) {
+	defer conn.Close()
+
+	dest := t.destination(conn)
+	reader := bufio.NewReader(conn)
+	client := &bufferedConn{Conn: conn, reader: reader}
+
+	// The Host header names the virtual host; dest covers HTTP/1.0
+	// clients that don't send one
+	if t.proxy.servePlaintext(client, dest, false) {
+		return
+	}
+
+	host := dest
+	if serverName := sniffSNI(conn, reader); serverName != "" {
+		port := "443"
+		if dest != "" {
+			_, port, _ = net.SplitHostPort(dest)
+		}
+		host = net.JoinHostPort(serverName, port)
+	}
+	if dest == "" {
+		dest = host
+	}
+	if dest == "" {
+		log.Printf("⚠️  Dropping transparent connection from %s: original destination unknown", conn.RemoteAddr())
+		return
+	}
+
+	log.Printf("🔀 Transparent connection to %s", host)
+	if t.proxy.mitm != nil {
+		t.proxy.interceptTLS(client, host, false)
+		return
+	}
+	t.proxy.tunnelConn(client, dest, host)
+}
+
+// destination recovers where the client was connecting to, or "" when the
+// connection was made to the listener directly.
+func (t *TransparentListener) destination(conn net.Conn) string {
+	if t.tproxy {
+		return conn.LocalAddr().String()
+	}
+
+	dest, err := originalDestination(conn)
+	if err != nil || dest == conn.LocalAddr().String() {
+		return ""
+	}
+	return dest
+}
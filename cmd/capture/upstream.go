@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// UpstreamProxy chains the capture proxy's outbound connections through
+// another proxy. The proxy URL may use the http, https, socks5 or socks5h
+// scheme, with credentials as userinfo. NoProxy follows NO_PROXY semantics:
+// comma-separated hosts (matching subdomains too), IPs, CIDRs and "*";
+// loopback destinations are never proxied.
+//
+// Upstream APIs are reached without certificate checks, but an https proxy's
+// certificate is verified (unless insecure is set) because it is sent the
+// Proxy-Authorization credentials.
+type UpstreamProxy struct {
+	proxyURL  *url.URL
+	proxyFunc func(*url.URL) (*url.URL, error)
+	tlsConfig *tls.Config
+}
+
+// upstreamDialTimeout bounds connecting to an upstream or upstream proxy.
+const upstreamDialTimeout = 30 * time.Second
+
+// NewUpstreamProxy configures chaining through the proxy at rawURL. For an
+// https proxy it makes tlsConfig verify the proxy's certificate unless
+// insecure is set.
+func NewUpstreamProxy(rawURL, noProxy string, tlsConfig *tls.Config, insecure bool) (*UpstreamProxy, error) {
+	proxyURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream proxy %q: %w", rawURL, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported upstream proxy scheme %q (want http, https, socks5 or socks5h)", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("upstream proxy %q has no host", rawURL)
+	}
+
+	if proxyURL.Scheme == "https" && !insecure {
+		tlsConfig.VerifyConnection = verifyServer(proxyURL.Hostname(), tlsConfig.VerifyConnection)
+	}
+
+	config := &httpproxy.Config{
+		HTTPProxy:  rawURL,
+		HTTPSProxy: rawURL,
+		NoProxy:    noProxy,
+	}
+	return &UpstreamProxy{
+		proxyURL:  proxyURL,
+		proxyFunc: config.ProxyFunc(),
+		tlsConfig: tlsConfig,
+	}, nil
+}
+
+// Proxy is used as the forwarding Transport's Proxy function.
+func (u *UpstreamProxy) Proxy(req *http.Request) (*url.URL, error) {
+	return u.proxyFunc(req.URL)
+}
+
+// Dial opens a connection to addr for a CONNECT tunnel, going through the
+// upstream proxy unless addr is bypassed.
+func (u *UpstreamProxy) Dial(addr string) (net.Conn, error) {
+	proxyURL, err := u.proxyFunc(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return net.DialTimeout("tcp", addr, upstreamDialTimeout)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, &net.Dialer{Timeout: upstreamDialTimeout})
+		if err != nil {
+			return nil, err
+		}
+		return dialer.Dial("tcp", addr)
+	default:
+		return u.dialConnect(proxyURL, addr)
+	}
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request to an
+// http or https upstream proxy.
+func (u *UpstreamProxy) dialConnect(proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := net.DialTimeout("tcp", proxyAddr, upstreamDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		config := u.tlsConfig.Clone()
+		config.ServerName = proxyURL.Hostname()
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("upstream proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+
+	// Anything the proxy sent after its response belongs to the tunnel
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// verifyServer returns a tls.Config.VerifyConnection hook that checks the
+// certificate chain of connections to host against the system roots, even
+// with InsecureSkipVerify set, and defers to next for other connections.
+func verifyServer(host string, next func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if state.ServerName != host {
+			if next != nil {
+				return next(state)
+			}
+			return nil
+		}
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("upstream proxy sent no certificate")
+		}
+		options := x509.VerifyOptions{DNSName: host, Intermediates: x509.NewCertPool()}
+		for _, cert := range state.PeerCertificates[1:] {
+			options.Intermediates.AddCert(cert)
+		}
+		if _, err := state.PeerCertificates[0].Verify(options); err != nil {
+			return fmt.Errorf("upstream proxy certificate: %w (set UPSTREAM_PROXY_INSECURE=true to skip)", err)
+		}
+		return nil
+	}
+}
+
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// SetUpstreamProxy sends forwarded requests and CONNECT tunnels through the
+// proxy at rawURL, except for destinations matched by noProxy. insecure
+// skips verifying an https proxy's certificate.
+func (cp *CaptureProxy) SetUpstreamProxy(rawURL, noProxy string, insecure bool) error {
+	upstream, err := NewUpstreamProxy(rawURL, noProxy, cp.transport.TLSClientConfig, insecure)
+	if err != nil {
+		return err
+	}
+	cp.upstream = upstream
+	cp.transport.Proxy = upstream.Proxy
+	log.Printf("Chaining outbound traffic through %s", upstream.proxyURL.Redacted())
+	if noProxy != "" {
+		log.Printf("Bypassing upstream proxy for: %s", noProxy)
+	}
+	return nil
+}
+
+// dialTunnel connects a CONNECT tunnel to addr, through the upstream proxy
+// when one is configured.
+func (cp *CaptureProxy) dialTunnel(addr string) (net.Conn, error) {
+	if cp.upstream == nil {
+		return net.DialTimeout("tcp", addr, upstreamDialTimeout)
+	}
+	return cp.upstream.Dial(addr)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// supportedEncodings are the content codings the mock can produce, in the
+// order they are preferred when the client rates them equally.
+var supportedEncodings = []string{"br", "gzip", "deflate"}
+
+// negotiateEncoding picks the content coding for a response from the
+// request's Accept-Encoding. The encoding the route was captured with wins
+// when the client accepts it; an empty result means identity.
+func negotiateEncoding(acceptEncoding, recorded string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding == "" {
+			continue
+		}
+		weight := 1.0
+		for _, param := range fields[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					weight = q
+				}
+			}
+		}
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+		weights[coding] = weight
+	}
+
+	accepted := func(coding string) float64 {
+		if weight, ok := weights[coding]; ok {
+			return weight
+		}
+		return weights["*"]
+	}
+
+	recorded = strings.ToLower(strings.TrimSpace(recorded))
+	if recorded == "x-gzip" {
+		recorded = "gzip"
+	}
+	if accepted(recorded) > 0 {
+		for _, coding := range supportedEncodings {
+			if coding == recorded {
+				return recorded
+			}
+		}
+	}
+
+	best, bestWeight := "", 0.0
+	for _, coding := range supportedEncodings {
+		if weight := accepted(coding); weight > bestWeight {
+			best, bestWeight = coding, weight
+		}
+	}
+	return best
+}
+
+// encodeBody compresses data with one of supportedEncodings.
+func encodeBody(data []byte, coding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch coding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	case "br":
+		writer = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", coding)
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"firecracker/mock-api-server/internal/graphql"
+)
+
+// GraphQLMatcher narrows a route to particular GraphQL operations on a
+// shared endpoint such as POST /graphql. Every field that is set must match:
+// Variables is a subset of the request's variables and QueryHash the SHA-256
+// hex of the query text (or the persisted query hash the client sent).
+type GraphQLMatcher struct {
+	OperationName string                 `json:"operation_name,omitempty"`
+	OperationType string                 `json:"operation_type,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+	QueryHash     string                 `json:"query_hash,omitempty"`
+}
+
+// graphQLRequest is the part of a GraphQL request the matchers look at.
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+	Extensions    struct {
+		PersistedQuery struct {
+			SHA256Hash string `json:"sha256Hash"`
+		} `json:"persistedQuery"`
+	} `json:"extensions"`
+}
+
+// parseGraphQLRequest reads a GraphQL request from a JSON POST body or GET
+// parameters. The body is left readable for validation.
+func parseGraphQLRequest(r *http.Request) (*graphQLRequest, bool) {
+	var req graphQLRequest
+	if r.Method == http.MethodGet {
+		params := r.URL.Query()
+		req.Query, req.OperationName = params.Get("query"), params.Get("operationName")
+		if variables := params.Get("variables"); variables != "" {
+			json.Unmarshal([]byte(variables), &req.Variables)
+		}
+		if extensions := params.Get("extensions"); extensions != "" {
+			json.Unmarshal([]byte(extensions), &req.Extensions)
+		}
+	} else {
+		if r.Body == nil {
+			return nil, false
+		}
+		body, err := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil || json.Unmarshal(body, &req) != nil {
+			return nil, false
+		}
+	}
+
+	if req.Query == "" && req.Extensions.PersistedQuery.SHA256Hash == "" {
+		return nil, false
+	}
+	return &req, true
+}
+
+func (req *graphQLRequest) hash() string {
+	if req.Query == "" {
+		return req.Extensions.PersistedQuery.SHA256Hash
+	}
+	sum := sha256.Sum256([]byte(req.Query))
+	return hex.EncodeToString(sum[:])
+}
+
+// operation returns the name and type of the operation the request runs.
+func (req *graphQLRequest) operation() (name, operationType string) {
+	name, operationType = graphql.ParseOperation(req.Query, req.OperationName)
+	if req.OperationName != "" {
+		name = req.OperationName
+	}
+	return name, operationType
+}
+
+func (m *GraphQLMatcher) matches(req *graphQLRequest) bool {
+	name, operationType := req.operation()
+	if m.OperationName != "" && m.OperationName != name {
+		return false
+	}
+	if m.OperationType != "" && req.Query != "" && m.OperationType != operationType {
+		return false
+	}
+	if m.QueryHash != "" && !strings.EqualFold(m.QueryHash, req.hash()) {
+		return false
+	}
+	return jsonSubset(m.Variables, req.Variables)
+}
+
+// specificity ranks matchers so the most precise matching route wins.
+func (m *GraphQLMatcher) specificity() int {
+	score := len(m.Variables)
+	if m.OperationName != "" {
+		score += 10
+	}
+	if m.QueryHash != "" {
+		score += 10
+	}
+	return score
+}
+
+// jsonSubset reports whether every key in want has an equal value in got,
+// comparing nested objects the same way.
+func jsonSubset(want, got map[string]interface{}) bool {
+	for key, wantValue := range want {
+		gotValue, ok := got[key]
+		if !ok {
+			return false
+		}
+		wantObject, wantIsObject := wantValue.(map[string]interface{})
+		gotObject, gotIsObject := gotValue.(map[string]interface{})
+		if wantIsObject && gotIsObject {
+			if !jsonSubset(wantObject, gotObject) {
+				return false
+			}
+		} else if !reflect.DeepEqual(wantValue, gotValue) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectGraphQLRoute picks the most specific route whose matcher accepts
+// req; status, when non-zero, restricts the choice to that status variant.
+// Earlier routes win ties.
+func selectGraphQLRoute(routes []RouteConfig, req *graphQLRequest, status int) *RouteConfig {
+	var best *RouteConfig
+	for i := range routes {
+		route := &routes[i]
+		if status != 0 && routeStatus(*route) != status {
+			continue
+		}
+		if !route.GraphQL.matches(req) {
+			continue
+		}
+		if best == nil || route.GraphQL.specificity() > best.GraphQL.specificity() {
+			best = route
+		}
+	}
+	return best
+}
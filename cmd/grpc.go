@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// gRPC status codes used by the mock itself.
+const (
+	grpcInternal      = 13
+	grpcUnimplemented = 12
+)
+
+// GRPCConfig turns a route into a gRPC method. The route's response is the
+// unary reply; Stream, when set, is sent as a server stream instead. Messages
+// are protojson, encoded with the method's descriptor, or with Format "raw"
+// objects keyed by field number as written by the capture proxy.
+type GRPCConfig struct {
+	Service  string            `json:"service,omitempty"`
+	Method   string            `json:"method,omitempty"`
+	Status   int               `json:"status"`
+	Message  string            `json:"message,omitempty"`
+	Trailers map[string]string `json:"trailers,omitempty"`
+	Stream   []interface{}     `json:"stream,omitempty"`
+	Format   string            `json:"format,omitempty"`
+	// FileDescriptorSet for the service, relative to the config directory;
+	// GRPC_DESCRIPTORS applies to routes without one
+	Descriptor string `json:"descriptor,omitempty"`
+	// Milliseconds between streamed messages
+	StreamDelay int `json:"stream_delay,omitempty"`
+
+	output protoreflect.MessageDescriptor
+}
+
+// loadDescriptorSet reads a serialized FileDescriptorSet, as written by
+// protoc --include_imports --descriptor_set_out.
+func loadDescriptorSet(path string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse descriptor set %s: %w", path, err)
+	}
+	return protodesc.NewFiles(&set)
+}
+
+// LoadGRPCDescriptors loads comma-separated descriptor sets used by gRPC
+// routes that don't name their own.
+func (ms *MockServer) LoadGRPCDescriptors(paths string) error {
+	var set descriptorpb.FileDescriptorSet
+	for _, path := range strings.Split(paths, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var part descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(data, &part); err != nil {
+			return fmt.Errorf("failed to parse descriptor set %s: %w", path, err)
+		}
+		set.File = append(set.File, part.File...)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return err
+	}
+	ms.descriptors = files
+	log.Printf("Loaded gRPC descriptors from %s", paths)
+	return nil
+}
+
+// compileGRPC resolves the message type a gRPC route replies with.
+func (ms *MockServer) compileGRPC(config *GRPCConfig, path string) error {
+	if config.Format == "raw" {
+		return nil
+	}
+
+	files := ms.descriptors
+	if config.Descriptor != "" {
+		loaded, err := loadDescriptorSet(resolveConfigPath(ms.configPath, config.Descriptor))
+		if err != nil {
+			return err
+		}
+		files = loaded
+	}
+	if files == nil {
+		return fmt.Errorf("no descriptor for %s; set grpc.descriptor or GRPC_DESCRIPTORS, or use format \"raw\"", path)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) != 2 {
+		return fmt.Errorf("gRPC route path %q is not /package.Service/Method", path)
+	}
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(parts[0]))
+	if err != nil {
+		return fmt.Errorf("service %s: %w", parts[0], err)
+	}
+	service, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", parts[0])
+	}
+	method := service.Methods().ByName(protoreflect.Name(parts[1]))
+	if method == nil {
+		return fmt.Errorf("service %s has no method %s", parts[0], parts[1])
+	}
+	config.output = method.Output()
+	return nil
+}
+
+// encode turns one JSON message into protobuf wire format.
+func (config *GRPCConfig) encode(message interface{}) ([]byte, error) {
+	if config.Format == "raw" {
+		return encodeRawMessage(message)
+	}
+	if config.output == nil {
+		return nil, fmt.Errorf("no descriptor loaded for this method")
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(config.output)
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("response doesn't match %s: %w", config.output.FullName(), err)
+	}
+	return proto.Marshal(msg)
+}
+
+// encodeRawMessage is the inverse of the capture proxy's raw decoding:
+// object keys are field numbers, arrays are repeated fields, and
+// {"@bytes"}, {"@fixed32"} and {"@fixed64"} select those wire types.
+func encodeRawMessage(value interface{}) ([]byte, error) {
+	fields, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("raw message must be an object keyed by field number, got %T", value)
+	}
+	if data, ok := fields["@bytes"].(string); ok && len(fields) == 1 {
+		return base64.StdEncoding.DecodeString(data)
+	}
+
+	numbers := make([]int, 0, len(fields))
+	for key := range fields {
+		num, err := strconv.Atoi(key)
+		if err != nil || num < 1 {
+			return nil, fmt.Errorf("raw message key %q is not a field number", key)
+		}
+		numbers = append(numbers, num)
+	}
+	sort.Ints(numbers)
+
+	var b []byte
+	for _, num := range numbers {
+		values, ok := fields[strconv.Itoa(num)].([]interface{})
+		if !ok {
+			values = []interface{}{fields[strconv.Itoa(num)]}
+		}
+		for _, v := range values {
+			var err error
+			if b, err = appendRawField(b, protowire.Number(num), v); err != nil {
+				return nil, fmt.Errorf("field %d: %w", num, err)
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendRawField(b []byte, num protowire.Number, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return b, nil
+	case bool:
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		return protowire.AppendVarint(b, protowire.EncodeBool(v)), nil
+	case float64:
+		if v != math.Trunc(v) {
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			return protowire.AppendFixed64(b, math.Float64bits(v)), nil
+		}
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		if v < 0 {
+			return protowire.AppendVarint(b, uint64(int64(v))), nil
+		}
+		return protowire.AppendVarint(b, uint64(v)), nil
+	case string:
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, v), nil
+	case map[string]interface{}:
+		if n, ok := v["@fixed32"].(float64); ok && len(v) == 1 {
+			b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+			return protowire.AppendFixed32(b, uint32(n)), nil
+		}
+		if n, ok := v["@fixed64"].(float64); ok && len(v) == 1 {
+			b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+			return protowire.AppendFixed64(b, uint64(n)), nil
+		}
+		nested, err := encodeRawMessage(v)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, nested), nil
+	}
+	return nil, fmt.Errorf("unsupported raw value %T", value)
+}
+
+// writeGRPCResponse answers a gRPC call: length-prefixed messages followed
+// by grpc-status, grpc-message and the route's trailers. Without messages
+// the status goes in the headers (a Trailers-Only response).
+func writeGRPCResponse(c echo.Context, route *RouteConfig, response interface{}) error {
+	config := route.GRPC
+	req := c.Request()
+	if req.ProtoMajor < 2 {
+		log.Printf("   gRPC route served over %s; gRPC clients need HTTP/2 (H2C=true or TLS_PORT)", req.Proto)
+	}
+
+	messages := config.Stream
+	if len(messages) == 0 && response != nil {
+		messages = []interface{}{response}
+	}
+	frames := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		data, err := config.encode(message)
+		if err != nil {
+			log.Printf("⚠️  Cannot encode gRPC response for %s: %v", req.URL.Path, err)
+			return writeGRPCStatus(c, grpcInternal, "mock: "+err.Error(), nil)
+		}
+		frames = append(frames, data)
+	}
+
+	header := c.Response().Header()
+	for _, name := range []string{"Grpc-Status", "Grpc-Message", "Grpc-Encoding", "Trailer"} {
+		header.Del(name)
+	}
+	if len(frames) == 0 {
+		return writeGRPCStatus(c, config.Status, config.Message, config.Trailers)
+	}
+
+	header.Set("Content-Type", "application/grpc")
+	c.Response().WriteHeader(http.StatusOK)
+	for i, data := range frames {
+		if i > 0 && config.StreamDelay > 0 {
+			time.Sleep(time.Duration(config.StreamDelay) * time.Millisecond)
+		}
+		prefix := make([]byte, 5)
+		binary.BigEndian.PutUint32(prefix[1:], uint32(len(data)))
+		c.Response().Write(prefix)
+		c.Response().Write(data)
+		c.Response().Flush()
+	}
+
+	header.Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(config.Status))
+	if config.Message != "" {
+		header.Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(config.Message))
+	}
+	for name, value := range config.Trailers {
+		header.Set(http.TrailerPrefix+name, value)
+	}
+	return nil
+}
+
+// writeGRPCStatus sends a Trailers-Only response.
+func writeGRPCStatus(c echo.Context, status int, message string, trailers map[string]string) error {
+	header := c.Response().Header()
+	header.Set("Content-Type", "application/grpc")
+	header.Set("Grpc-Status", strconv.Itoa(status))
+	if message != "" {
+		header.Set("Grpc-Message", encodeGRPCMessage(message))
+	}
+	for name, value := range trailers {
+		header.Set(name, value)
+	}
+	c.Response().WriteHeader(http.StatusOK)
+	return nil
+}
+
+// encodeGRPCMessage percent-encodes grpc-message as the gRPC spec requires.
+func encodeGRPCMessage(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		if c := message[i]; c >= 0x20 && c <= 0x7e && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// isGRPCRequest reports gRPC calls, which get a gRPC error rather than a
+// JSON 404 when no route matches.
+func isGRPCRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return strings.HasPrefix(contentType, "application/grpc") && !strings.HasPrefix(contentType, "application/grpc-web")
+}
+
+// unimplementedGRPC answers calls to methods no route defines.
+func unimplementedGRPC(c echo.Context) error {
+	message := fmt.Sprintf("mock: no route for %s", c.Request().URL.Path)
+	return writeGRPCStatus(c, grpcUnimplemented, message, nil)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runImportOpenAPI implements the "import-openapi" command, which converts an
+// OpenAPI 3 document into a RoutesFile with one route per operation and
+// documented status code.
+func runImportOpenAPI(args []string) error {
+	fs := flag.NewFlagSet("import-openapi", flag.ExitOnError)
+	specPath := fs.String("spec", "", "path to the OpenAPI 3 document (JSON or YAML)")
+	outPath := fs.String("out", "", "file to write the routes to (default stdout)")
+	fs.Parse(args)
+
+	if *specPath == "" && fs.NArg() > 0 {
+		*specPath = fs.Arg(0)
+	}
+	if *specPath == "" {
+		return fmt.Errorf("usage: import-openapi -spec openapi.json [-out configs/service.json]")
+	}
+
+	doc, err := LoadOpenAPIDocument(*specPath)
+	if err != nil {
+		return err
+	}
+
+	routesFile := RoutesFile{Routes: ImportOpenAPIRoutes(doc)}
+
+	data, err := json.MarshalIndent(routesFile, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if *outPath == "" {
+		_, err = os.Stdout.Write(append(data, '\n'))
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(*outPath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(*outPath, data, 0644); err != nil {
+		return err
+	}
+
+	log.Printf("Imported %d routes from %s into %s", len(routesFile.Routes), *specPath, *outPath)
+	return nil
+}
+
+// ImportOpenAPIRoutes generates mock routes for every operation in the
+// document. Each operation is mounted under every distinct server base path.
+func ImportOpenAPIRoutes(doc *OpenAPIDocument) []RouteConfig {
+	var routes []RouteConfig
+
+	for _, op := range doc.Operations() {
+		op := op
+		prefixes := op.Servers
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+
+		for _, response := range doc.Responses(&op) {
+			for _, prefix := range prefixes {
+				description := op.Summary()
+				if response.Description != "" {
+					description = fmt.Sprintf("%s (%d %s)", description, response.Status, strings.TrimSpace(response.Description))
+				}
+
+				routes = append(routes, RouteConfig{
+					Method:      op.Method,
+					Path:        prefix + op.Path,
+					Status:      response.Status,
+					Response:    response.Body,
+					Headers:     response.Headers,
+					Description: description,
+				})
+			}
+		}
+	}
+
+	return routes
+}
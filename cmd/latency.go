@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// LatencyConfig replays the response times recorded by the capture proxy
+// ("response_time_ms"). Mode is "off", "exact" (each route's own recording),
+// "scaled" (the recording multiplied by Scale) or "sampled" (a random
+// recording of the same method and path, so repeated captures give a
+// realistic distribution). Scale also applies to exact and sampled replay
+// when set.
+type LatencyConfig struct {
+	Mode  string  `json:"mode"`
+	Scale float64 `json:"scale,omitempty"`
+}
+
+func (config LatencyConfig) validate() error {
+	switch config.Mode {
+	case "", "off", "exact", "scaled", "sampled":
+	default:
+		return fmt.Errorf("invalid latency mode %q (want off, exact, scaled or sampled)", config.Mode)
+	}
+	if config.Scale < 0 {
+		return fmt.Errorf("invalid latency scale %v", config.Scale)
+	}
+	return nil
+}
+
+// ParseLatencyConfig builds the global replay mode from LATENCY_MODE and
+// LATENCY_SCALE.
+func ParseLatencyConfig(mode, scale string) (LatencyConfig, error) {
+	config := LatencyConfig{Mode: mode}
+	if scale != "" {
+		factor, err := strconv.ParseFloat(scale, 64)
+		if err != nil {
+			return config, fmt.Errorf("invalid LATENCY_SCALE %q", scale)
+		}
+		config.Scale = factor
+	}
+	return config, config.validate()
+}
+
+// latencyKey groups routes whose recordings form one distribution. GraphQL
+// operations share an endpoint but not their timings.
+func latencyKey(key string, route RouteConfig) string {
+	if route.GraphQL != nil {
+		return key + "#" + route.GraphQL.OperationName + route.GraphQL.QueryHash
+	}
+	return key
+}
+
+// responseDelay is how long to wait before answering with route. An explicit
+// "delay" wins over recorded latency. The caller holds routesMu, which
+// guards the latency samples.
+func (ms *MockServer) responseDelay(key string, route *RouteConfig) time.Duration {
+	if route.Delay > 0 {
+		return time.Duration(route.Delay) * time.Millisecond
+	}
+
+	config := ms.latency
+	if route.Latency != nil {
+		config = *route.Latency
+	}
+
+	var recorded int64
+	switch config.Mode {
+	case "exact", "scaled":
+		recorded = route.ResponseTime
+	case "sampled":
+		recorded = route.ResponseTime
+		if samples := ms.latencySamples[latencyKey(key, *route)]; len(samples) > 0 {
+			recorded = samples[rand.Intn(len(samples))]
+		}
+	default:
+		return 0
+	}
+
+	scale := config.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	return time.Duration(float64(recorded) * scale * float64(time.Millisecond))
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,6 +15,8 @@ import (
 	"github.com/fsnotify/fsnotify"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/net/http2"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 type RouteConfig struct {
@@ -24,6 +27,39 @@ type RouteConfig struct {
 	Headers     map[string]string      `json:"headers"`
 	Delay       int                    `json:"delay"`
 	Description string                 `json:"description"`
+	Validation  *ValidationConfig      `json:"validation,omitempty"`
+	// Lossless response headers (repeated Set-Cookie, Link, ...). Takes
+	// precedence over Headers for the names it contains.
+	HeaderList []HeaderField `json:"header_list,omitempty"`
+	// Content-Encoding the response was captured with; the mock re-encodes
+	// to whatever supported encoding the request's Accept-Encoding allows.
+	ContentEncoding string `json:"content_encoding,omitempty"`
+	// Protocol the response was captured over, e.g. "HTTP/2.0". Clients get
+	// HTTP/2 from the TLS_PORT listener or with H2C=true.
+	Protocol string `json:"protocol,omitempty"`
+	// Serves the route as a gRPC method (status, trailers, streaming)
+	GRPC *GRPCConfig `json:"grpc,omitempty"`
+	// Restricts the route to matching GraphQL operations on its endpoint
+	GraphQL *GraphQLMatcher `json:"graphql,omitempty"`
+	// Response time recorded by the capture proxy, replayed according to
+	// Latency or LATENCY_MODE when the route has no Delay
+	ResponseTime int64          `json:"response_time_ms,omitempty"`
+	Latency      *LatencyConfig `json:"latency,omitempty"`
+	// TTFB, bandwidth and jitter for this route instead of SHAPE_*
+	Shaping *ShapingConfig `json:"shaping,omitempty"`
+	// Token bucket for this route instead of RATE_LIMIT_CONFIG
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+	// Webhooks sent after the response, e.g. a payment provider's result
+	Callbacks []CallbackConfig `json:"callbacks,omitempty"`
+	// Serves the path as an in-memory CRUD collection; Method is ignored
+	Resource *ResourceConfig `json:"resource,omitempty"`
+
+	validator *requestValidator
+}
+
+type HeaderField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
 }
 
 type RoutesFile struct {
@@ -33,10 +69,32 @@ type RoutesFile struct {
 type MockServer struct {
 	echo       *echo.Echo
 	routes     map[string]RouteConfig
+	variants   map[string]map[int]RouteConfig
 	routesMu   sync.RWMutex
 	configPath string
+
+	// GraphQL routes by method and path, in load order
+	graphql map[string][]RouteConfig
+	// Resource routes in load order and their stores by path
+	resourceRoutes []RouteConfig
+	resources      map[string]*resourceStore
+	// Recorded response times per method and path (see latencyKey)
+	latencySamples map[string][]int64
+	latency        LatencyConfig
+	shapingDefault ShapingConfig
+	rateLimit      *RateLimitConfig
+	limiter        *rateLimiter
+	callbacks      *callbackLog
+	// Recently rejected requests (GET /api/validation-failures)
+	validationFailures *validationLog
+	// Fallback descriptors for gRPC routes (GRPC_DESCRIPTORS)
+	descriptors *protoregistry.Files
 }
 
+// statusHeader selects an alternative response when several routes share a
+// method and path but differ in status (e.g. imported OpenAPI error responses).
+const statusHeader = "X-Mock-Status"
+
 func NewMockServer(configPath string) *MockServer {
 	e := echo.New()
 	e.Use(middleware.Logger())
@@ -50,12 +108,25 @@ func NewMockServer(configPath string) *MockServer {
 	ms := &MockServer{
 		echo:       e,
 		routes:     make(map[string]RouteConfig),
+		variants:   make(map[string]map[int]RouteConfig),
+		graphql:    make(map[string][]RouteConfig),
+		resources:  make(map[string]*resourceStore),
 		configPath: configPath,
+		limiter:    newRateLimiter(),
+		callbacks:  &callbackLog{},
+
+		validationFailures: &validationLog{},
 	}
 
 	// API endpoints for viewer
 	e.GET("/api/files/:dir", ms.handleListFiles)
 	e.GET("/api/file/:dir/*", ms.handleGetFile)
+	e.DELETE("/api/rate-limits", ms.handleResetRateLimits)
+	e.GET("/api/callbacks", ms.handleListCallbacks)
+	e.GET("/api/validation-failures", ms.handleListValidationFailures)
+	e.DELETE("/api/validation-failures", ms.handleClearValidationFailures)
+	e.GET("/api/resources", ms.handleListResources)
+	e.POST("/api/resources/reset", ms.handleResetResources)
 	
 	// Serve viewer HTML explicitly before catch-all
 	e.GET("/viewer.html", ms.serveViewer)
@@ -72,6 +143,13 @@ func (ms *MockServer) loadRoutes() error {
 	defer ms.routesMu.Unlock()
 
 	ms.routes = make(map[string]RouteConfig)
+	ms.variants = make(map[string]map[int]RouteConfig)
+	ms.graphql = make(map[string][]RouteConfig)
+	ms.latencySamples = make(map[string][]int64)
+	// Stores survive reloads unless their definition changed
+	previousResources := ms.resources
+	ms.resourceRoutes = nil
+	ms.resources = make(map[string]*resourceStore)
 
 	pattern := filepath.Join(ms.configPath, "*.json")
 	files, err := filepath.Glob(pattern)
@@ -95,7 +173,102 @@ func (ms *MockServer) loadRoutes() error {
 
 		for _, route := range routesFile.Routes {
 			key := fmt.Sprintf("%s:%s", strings.ToUpper(route.Method), route.Path)
-			ms.routes[key] = route
+
+			if route.Validation != nil {
+				validator, err := compileValidation(route.Validation, ms.configPath)
+				if err != nil {
+					log.Printf("Error loading validation for %s from %s: %v", key, filepath.Base(file), err)
+					validator = &requestValidator{config: route.Validation, err: err}
+				}
+				route.validator = validator
+			}
+			if route.Latency != nil {
+				if err := route.Latency.validate(); err != nil {
+					log.Printf("Error loading latency for %s from %s: %v", key, filepath.Base(file), err)
+					route.Latency = nil
+				}
+			}
+			if route.Shaping != nil {
+				if err := route.Shaping.validate(); err != nil {
+					log.Printf("Error loading shaping for %s from %s: %v", key, filepath.Base(file), err)
+					route.Shaping = nil
+				}
+			}
+			if route.RateLimit != nil {
+				if err := route.RateLimit.validate(); err != nil {
+					log.Printf("Error loading rate limit for %s from %s: %v", key, filepath.Base(file), err)
+					route.RateLimit = nil
+				}
+			}
+			if len(route.Callbacks) > 0 {
+				callbacks := route.Callbacks[:0:0]
+				for _, callback := range route.Callbacks {
+					if err := callback.validate(); err != nil {
+						log.Printf("Error loading callback for %s from %s: %v", key, filepath.Base(file), err)
+						continue
+					}
+					callbacks = append(callbacks, callback)
+				}
+				route.Callbacks = callbacks
+			}
+			if route.ResponseTime > 0 {
+				sampleKey := latencyKey(key, route)
+				ms.latencySamples[sampleKey] = append(ms.latencySamples[sampleKey], route.ResponseTime)
+			}
+			if route.GRPC != nil {
+				if err := ms.compileGRPC(route.GRPC, route.Path); err != nil {
+					log.Printf("Error loading gRPC descriptors for %s from %s: %v", key, filepath.Base(file), err)
+				}
+			}
+
+			if route.Resource != nil {
+				if err := route.Resource.validate(); err != nil {
+					log.Printf("Error loading resource for %s from %s: %v", route.Path, filepath.Base(file), err)
+					continue
+				}
+				if _, duplicate := ms.resources[route.Path]; duplicate {
+					log.Printf("Error loading resource for %s from %s: another resource already serves this path", route.Path, filepath.Base(file))
+					continue
+				}
+				store, err := newResourceStore(*route.Resource, ms.configPath)
+				if err != nil {
+					log.Printf("Error loading resource for %s from %s: %v", route.Path, filepath.Base(file), err)
+					continue
+				}
+				if previous, ok := previousResources[route.Path]; ok && previous.signature == store.signature {
+					store = previous
+				}
+				ms.resources[route.Path] = store
+				ms.resourceRoutes = append(ms.resourceRoutes, route)
+				totalRoutes++
+				continue
+			}
+
+			// GraphQL routes share an endpoint and are told apart by their
+			// matchers; they only stand in for path matching when the
+			// endpoint has no plain route
+			if route.GraphQL != nil {
+				ms.graphql[key] = append(ms.graphql[key], route)
+				if _, ok := ms.routes[key]; !ok {
+					ms.routes[key] = route
+				}
+				totalRoutes++
+				continue
+			}
+			if existing, ok := ms.routes[key]; ok && existing.GraphQL != nil {
+				delete(ms.routes, key)
+			}
+
+			if ms.variants[key] == nil {
+				ms.variants[key] = make(map[int]RouteConfig)
+			}
+			ms.variants[key][routeStatus(route)] = route
+
+			// Routes sharing a method and path but differing in status are
+			// kept as variants; the lowest status is served by default.
+			if existing, ok := ms.routes[key]; !ok || routeStatus(route) <= routeStatus(existing) {
+				ms.routes[key] = route
+			}
 			totalRoutes++
 		}
 
@@ -175,7 +348,21 @@ func (ms *MockServer) serveViewer(c echo.Context) error {
 	return c.File("viewer.html")
 }
 
-func (ms *MockServer) handleRequest(c echo.Context) error {
+// routeMatch is the route a request resolved to, copied out of the route
+// tables so routesMu is not held while the response is delayed, shaped or
+// written.
+type routeMatch struct {
+	key        string
+	route      RouteConfig
+	params     map[string]string
+	resourceID string
+	store      *resourceStore
+	delay      time.Duration
+}
+
+// matchRequest resolves the request against the loaded routes. When nothing
+// matches it returns nil and a function writing the reason.
+func (ms *MockServer) matchRequest(c echo.Context) (*routeMatch, func() error) {
 	ms.routesMu.RLock()
 	defer ms.routesMu.RUnlock()
 
@@ -183,7 +370,9 @@ func (ms *MockServer) handleRequest(c echo.Context) error {
 	method := c.Request().Method
 
 	var matchedRoute *RouteConfig
+	var matchedKey string
 	var matchedParams map[string]string
+	var resourceID string
 
 	for key, route := range ms.routes {
 		routeMethod := strings.Split(key, ":")[0]
@@ -194,33 +383,143 @@ func (ms *MockServer) handleRequest(c echo.Context) error {
 		params, matched := matchPath(route.Path, path)
 		if matched {
 			matchedRoute = &route
+			matchedKey = key
 			matchedParams = params
 			break
 		}
 	}
 
+	if matchedRoute == nil {
+		if route, params, id, ok := ms.matchResource(path); ok {
+			matchedRoute, matchedParams, resourceID = route, params, id
+			matchedKey = method + ":" + route.Path
+			if id != "" {
+				matchedKey += "/{id}"
+			}
+		}
+	}
+
 	if matchedRoute == nil {
 		log.Printf("No route found for %s %s", method, path)
-		return c.JSON(http.StatusNotFound, map[string]interface{}{
-			"error":   "Route not found",
-			"method":  method,
-			"path":    path,
-			"message": "This endpoint has not been configured in the mock server",
-		})
+		if isGRPCRequest(c.Request()) {
+			return nil, func() error { return unimplementedGRPC(c) }
+		}
+		return nil, func() error {
+			return c.JSON(http.StatusNotFound, map[string]interface{}{
+				"error":   "Route not found",
+				"method":  method,
+				"path":    path,
+				"message": "This endpoint has not been configured in the mock server",
+			})
+		}
+	}
+
+	if routes := ms.graphql[matchedKey]; len(routes) > 0 {
+		requested, _ := strconv.Atoi(c.Request().Header.Get(statusHeader))
+		var selected *RouteConfig
+		if operation, ok := parseGraphQLRequest(c.Request()); ok {
+			selected = selectGraphQLRoute(routes, operation, requested)
+		}
+		if selected != nil {
+			matchedRoute = selected
+		} else if matchedRoute.GraphQL != nil {
+			log.Printf("No GraphQL route matches %s %s", method, path)
+			return nil, func() error {
+				return c.JSON(http.StatusNotFound, map[string]interface{}{
+					"errors": []map[string]interface{}{{
+						"message": "No GraphQL operation matching this request has been configured in the mock server",
+					}},
+				})
+			}
+		}
+	}
+
+	if requested := c.Request().Header.Get(statusHeader); requested != "" && matchedRoute.GraphQL == nil && matchedRoute.Resource == nil {
+		status, err := strconv.Atoi(requested)
+		variant, ok := ms.variants[matchedKey][status]
+		if err != nil || !ok {
+			return nil, func() error {
+				return c.JSON(http.StatusNotFound, map[string]interface{}{
+					"error":   "Status variant not found",
+					"method":  method,
+					"path":    path,
+					"status":  requested,
+					"message": "No route with this status has been configured for this endpoint",
+				})
+			}
+		}
+		matchedRoute = &variant
+	}
+
+	match := &routeMatch{
+		key:        matchedKey,
+		route:      *matchedRoute,
+		params:     matchedParams,
+		resourceID: resourceID,
+		delay:      ms.responseDelay(matchedKey, matchedRoute),
+	}
+	if matchedRoute.Resource != nil {
+		match.store = ms.resources[matchedRoute.Path]
+	}
+	return match, nil
+}
+
+func (ms *MockServer) handleRequest(c echo.Context) error {
+	match, miss := ms.matchRequest(c)
+	if match == nil {
+		return miss()
 	}
 
+	path := c.Request().URL.Path
+	method := c.Request().Method
+	matchedRoute, matchedKey, matchedParams := &match.route, match.key, match.params
+
 	log.Printf("Matched route: %s %s -> %s", method, path, matchedRoute.Description)
 
-	if matchedRoute.Delay > 0 {
-		time.Sleep(time.Duration(matchedRoute.Delay) * time.Millisecond)
+	if matchedRoute.Protocol != "" && c.Request().ProtoMajor < protocolMajor(matchedRoute.Protocol) {
+		log.Printf("   Route was captured over %s but is being served over %s", matchedRoute.Protocol, c.Request().Proto)
+	}
+
+	limitHeaders, allowed, err := ms.checkRateLimit(c, matchedKey, matchedRoute)
+	if !allowed {
+		return err
+	}
+
+	if validator := matchedRoute.validator; validator != nil {
+		if validator.err != nil {
+			return validator.loadFailure(c)
+		}
+		if violations := validator.Validate(c.Request()); len(violations) > 0 {
+			ms.validationFailures.add(ValidationRecord{
+				Route:      matchedKey,
+				Method:     method,
+				Path:       path,
+				Violations: violations,
+				At:         time.Now(),
+			})
+			return validator.validationFailure(c, violations)
+		}
+	}
+
+	if match.delay > 0 {
+		time.Sleep(match.delay)
+	}
+
+	if shaping := ms.shaping(matchedRoute); shaping.active() {
+		c.Response().Writer = newShapedWriter(c.Response().Writer, shaping)
 	}
 
-	for key, value := range matchedRoute.Headers {
-		c.Response().Header().Set(key, value)
+	applyRouteHeaders(c.Response().Header(), matchedRoute)
+	for name, values := range limitHeaders {
+		c.Response().Header()[name] = values
 	}
 
 	response := matchedRoute.Response
-	if responseStr, ok := response.(string); ok {
+	if matchedRoute.Resource != nil {
+		served := *matchedRoute
+		served.Status, response = serveResource(c, match.store, matchedParams, match.resourceID)
+		matchedRoute = &served
+	} else if responseStr, ok := response.(string); ok {
 		response = replacePlaceholders(responseStr, matchedParams)
 		var jsonResponse interface{}
 		if err := json.Unmarshal([]byte(response.(string)), &jsonResponse); err == nil {
@@ -228,12 +527,100 @@ func (ms *MockServer) handleRequest(c echo.Context) error {
 		}
 	}
 
-	status := matchedRoute.Status
-	if status == 0 {
-		status = http.StatusOK
+	var callbackCtx *callbackContext
+	if len(matchedRoute.Callbacks) > 0 {
+		callbackCtx = newCallbackContext(c, matchedParams, response)
+	}
+
+	if matchedRoute.GRPC != nil {
+		err = writeGRPCResponse(c, matchedRoute, response)
+	} else {
+		err = writeRouteResponse(c, matchedRoute, response)
+	}
+	if callbackCtx != nil {
+		ms.scheduleCallbacks(matchedKey, matchedRoute, callbackCtx)
+	}
+	return err
+}
+
+// writeRouteResponse writes the route's JSON response, compressing it when
+// the route was captured compressed and the client accepts an encoding.
+func writeRouteResponse(c echo.Context, route *RouteConfig, response interface{}) error {
+	status := routeStatus(*route)
+	if response == nil && status == http.StatusNoContent {
+		return c.NoContent(status)
+	}
+	if route.ContentEncoding == "" {
+		return c.JSON(status, response)
+	}
+
+	header := c.Response().Header()
+	if !strings.Contains(strings.ToLower(strings.Join(header.Values("Vary"), ",")), "accept-encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	coding := negotiateEncoding(c.Request().Header.Get("Accept-Encoding"), route.ContentEncoding)
+	if coding == "" {
+		return c.JSON(status, response)
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	encoded, err := encodeBody(append(data, '\n'), coding)
+	if err != nil {
+		return err
+	}
+	header.Set("Content-Encoding", coding)
+	return c.Blob(status, echo.MIMEApplicationJSON, encoded)
+}
+
+func protocolMajor(proto string) int {
+	major, _, ok := http.ParseHTTPVersion(proto)
+	if !ok && strings.HasPrefix(proto, "HTTP/2") {
+		return 2
+	}
+	return major
+}
+
+func routeStatus(route RouteConfig) int {
+	if route.Status == 0 {
+		return http.StatusOK
+	}
+	return route.Status
+}
+
+// applyRouteHeaders copies a route's headers onto the response. Framing and
+// encoding headers from captures are skipped since the mock writes (and
+// encodes) its own body.
+func applyRouteHeaders(h http.Header, route *RouteConfig) {
+	for key, value := range route.Headers {
+		if !isFramingHeader(key) {
+			h.Set(key, value)
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, field := range route.HeaderList {
+		name := http.CanonicalHeaderKey(field.Name)
+		if isFramingHeader(name) {
+			continue
+		}
+		if !listed[name] {
+			h.Del(name)
+			listed[name] = true
+		}
+		h.Add(name, field.Value)
 	}
+}
 
-	return c.JSON(status, response)
+func isFramingHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Connection", "Keep-Alive":
+		return true
+	}
+	return false
 }
 
 func matchPath(pattern, path string) (map[string]string, bool) {
@@ -307,7 +694,22 @@ func (ms *MockServer) watchConfigFiles() {
 	}
 }
 
+// commands are the offline tools built into the mock server binary, run as
+// "mock-server <command> [flags]".
+var commands = map[string]func(args []string) error{
+	"import-openapi": runImportOpenAPI,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8090"
@@ -320,6 +722,39 @@ func main() {
 
 	server := NewMockServer(configPath)
 
+	latency, err := ParseLatencyConfig(os.Getenv("LATENCY_MODE"), os.Getenv("LATENCY_SCALE"))
+	if err != nil {
+		log.Fatalf("Invalid latency replay settings: %v", err)
+	}
+	server.latency = latency
+	if latency.Mode != "" && latency.Mode != "off" {
+		log.Printf("⏱️  Replaying recorded latency (mode: %s)", latency.Mode)
+	}
+
+	shaping, err := ParseShapingConfig(os.Getenv("SHAPE_TTFB_MS"), os.Getenv("SHAPE_BYTES_PER_SEC"), os.Getenv("SHAPE_JITTER_MS"))
+	if err != nil {
+		log.Fatalf("Invalid response shaping settings: %v", err)
+	}
+	server.shapingDefault = shaping
+	if shaping.active() {
+		log.Printf("🐢 Shaping responses: TTFB %dms, %d bytes/sec, jitter %dms", shaping.TTFB, shaping.BytesPerSec, shaping.Jitter)
+	}
+
+	if rateLimitPath := os.Getenv("RATE_LIMIT_CONFIG"); rateLimitPath != "" {
+		rateLimit, err := LoadRateLimitConfig(rateLimitPath)
+		if err != nil {
+			log.Fatalf("Failed to load rate limit config: %v", err)
+		}
+		server.rateLimit = rateLimit
+		log.Printf("🚦 Rate limiting all routes to %d requests per %gs", rateLimit.Requests, rateLimit.WindowSeconds)
+	}
+
+	if descriptorPaths := os.Getenv("GRPC_DESCRIPTORS"); descriptorPaths != "" {
+		if err := server.LoadGRPCDescriptors(descriptorPaths); err != nil {
+			log.Fatalf("Failed to load gRPC descriptors: %v", err)
+		}
+	}
+
 	if err := server.loadRoutes(); err != nil {
 		log.Printf("Warning: Failed to load initial routes: %v", err)
 	}
@@ -335,6 +770,28 @@ func main() {
 	log.Printf("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
 	log.Printf("")
 	
+	// HTTPS negotiates HTTP/2 with clients that offer it
+	if certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"); certFile != "" && keyFile != "" {
+		tlsPort := os.Getenv("TLS_PORT")
+		if tlsPort == "" {
+			tlsPort = "8443"
+		}
+		go func() {
+			log.Printf("🔒 HTTPS (HTTP/2 and HTTP/1.1) on port %s", tlsPort)
+			if err := server.echo.StartTLS(":"+tlsPort, certFile, keyFile); err != nil && err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	if os.Getenv("H2C") == "true" {
+		log.Printf("Serving cleartext HTTP/2 (h2c) alongside HTTP/1.1 on port %s", port)
+		if err := server.echo.StartH2CServer(":"+port, &http2.Server{}); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if err := server.echo.Start(":" + port); err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,587 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OpenAPIDocument is a parsed OpenAPI 3 document (JSON or YAML) kept as
+// generic JSON so that $ref pointers can be resolved against any part of it.
+type OpenAPIDocument struct {
+	root map[string]interface{}
+}
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func LoadOpenAPIDocument(path string) (*OpenAPIDocument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root map[string]interface{}
+	if jsonErr := json.Unmarshal(data, &root); jsonErr != nil {
+		var document yaml.Node
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("failed to parse %s as JSON (%v) or YAML: %w", path, jsonErr, err)
+		}
+		value, err := yamlToJSON(&document)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		if root, _ = value.(map[string]interface{}); root == nil {
+			return nil, fmt.Errorf("failed to parse %s: not an OpenAPI document", path)
+		}
+	}
+
+	version, _ := root["openapi"].(string)
+	if !strings.HasPrefix(version, "3.") {
+		return nil, fmt.Errorf("%s is not an OpenAPI 3 document (openapi: %q)", path, version)
+	}
+
+	return &OpenAPIDocument{root: root}, nil
+}
+
+// yamlToJSON converts a YAML document to the values encoding/json produces:
+// string keys (YAML reads unquoted status codes such as 200 as ints),
+// float64 numbers, and timestamps kept as the text they were written as.
+func yamlToJSON(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlToJSON(node.Content[0])
+	case yaml.AliasNode:
+		return yamlToJSON(node.Alias)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		var merged []interface{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := yamlToJSON(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if node.Content[i].ShortTag() == "!!merge" {
+				merged = append(merged, value)
+				continue
+			}
+			m[node.Content[i].Value] = value
+		}
+		// "<<: *base" (or a list of them) fills in keys the mapping lacks
+		for _, value := range merged {
+			bases, ok := value.([]interface{})
+			if !ok {
+				bases = []interface{}{value}
+			}
+			for _, base := range bases {
+				base, _ := base.(map[string]interface{})
+				for k, v := range base {
+					if _, ok := m[k]; !ok {
+						m[k] = v
+					}
+				}
+			}
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(node.Content))
+		for i, child := range node.Content {
+			value, err := yamlToJSON(child)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = value
+		}
+		return list, nil
+	}
+
+	if node.ShortTag() == "!!timestamp" {
+		return node.Value, nil
+	}
+	var value interface{}
+	if err := node.Decode(&value); err != nil {
+		return nil, err
+	}
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	}
+	return value, nil
+}
+
+// resolve follows $ref pointers (local "#/..." references only) until it
+// reaches a concrete node.
+func (doc *OpenAPIDocument) resolve(node interface{}) map[string]interface{} {
+	m, _ := node.(map[string]interface{})
+	for i := 0; m != nil && i < 32; i++ {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		m, _ = doc.lookup(ref).(map[string]interface{})
+	}
+	return m
+}
+
+// lookup resolves a URI fragment JSON pointer. As RFC 6901 section 6 says,
+// the fragment is percent-decoded first, then ~1 and ~0 are unescaped in
+// each token.
+func (doc *OpenAPIDocument) lookup(ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	pointer, err := url.PathUnescape(ref[1:])
+	if err != nil {
+		return nil
+	}
+
+	var node interface{} = doc.root
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[token]
+	}
+	return node
+}
+
+// OpenAPIOperation is a single method on a path, flattened with the servers
+// and parameters it inherits from the path item and document.
+type OpenAPIOperation struct {
+	Method     string
+	Path       string
+	Servers    []string
+	Parameters []map[string]interface{}
+	Node       map[string]interface{}
+}
+
+func (op *OpenAPIOperation) ID() string {
+	id, _ := op.Node["operationId"].(string)
+	return id
+}
+
+func (op *OpenAPIOperation) Summary() string {
+	if summary, ok := op.Node["summary"].(string); ok && summary != "" {
+		return summary
+	}
+	if id := op.ID(); id != "" {
+		return id
+	}
+	return fmt.Sprintf("%s %s", op.Method, op.Path)
+}
+
+// Operations lists every operation in the document sorted by path and method.
+func (doc *OpenAPIDocument) Operations() []OpenAPIOperation {
+	paths, _ := doc.root["paths"].(map[string]interface{})
+
+	pathNames := make([]string, 0, len(paths))
+	for name := range paths {
+		pathNames = append(pathNames, name)
+	}
+	sort.Strings(pathNames)
+
+	docServers := doc.serverPrefixes(doc.root["servers"])
+
+	var ops []OpenAPIOperation
+	for _, pathName := range pathNames {
+		item := doc.resolve(paths[pathName])
+		if item == nil {
+			continue
+		}
+
+		servers := docServers
+		if s := doc.serverPrefixes(item["servers"]); len(s) > 0 {
+			servers = s
+		}
+
+		for _, method := range openAPIMethods {
+			node := doc.resolve(item[method])
+			if node == nil {
+				continue
+			}
+
+			opServers := servers
+			if s := doc.serverPrefixes(node["servers"]); len(s) > 0 {
+				opServers = s
+			}
+
+			ops = append(ops, OpenAPIOperation{
+				Method:     strings.ToUpper(method),
+				Path:       pathName,
+				Servers:    opServers,
+				Parameters: doc.mergeParameters(item["parameters"], node["parameters"]),
+				Node:       node,
+			})
+		}
+	}
+	return ops
+}
+
+// FindOperation looks an operation up by operationId, or by "METHOD /path".
+func (doc *OpenAPIDocument) FindOperation(ref string) (*OpenAPIOperation, error) {
+	for _, op := range doc.Operations() {
+		op := op
+		if op.ID() == ref || strings.EqualFold(op.Method+" "+op.Path, ref) {
+			return &op, nil
+		}
+	}
+	return nil, fmt.Errorf("operation %q not found", ref)
+}
+
+// mergeParameters combines path-level and operation-level parameters, with
+// operation parameters overriding those of the same name and location.
+func (doc *OpenAPIDocument) mergeParameters(pathParams, opParams interface{}) []map[string]interface{} {
+	var merged []map[string]interface{}
+	index := make(map[string]int)
+
+	for _, list := range []interface{}{pathParams, opParams} {
+		items, _ := list.([]interface{})
+		for _, raw := range items {
+			param := doc.resolve(raw)
+			if param == nil {
+				continue
+			}
+			key := fmt.Sprintf("%v:%v", param["in"], param["name"])
+			if i, ok := index[key]; ok {
+				merged[i] = param
+				continue
+			}
+			index[key] = len(merged)
+			merged = append(merged, param)
+		}
+	}
+	return merged
+}
+
+// serverPrefixes returns the distinct base paths of a servers list, with
+// server variables replaced by their defaults.
+func (doc *OpenAPIDocument) serverPrefixes(node interface{}) []string {
+	servers, _ := node.([]interface{})
+
+	var prefixes []string
+	seen := make(map[string]bool)
+	for _, raw := range servers {
+		server, _ := raw.(map[string]interface{})
+		if server == nil {
+			continue
+		}
+		serverURL, _ := server["url"].(string)
+
+		variables, _ := server["variables"].(map[string]interface{})
+		for name, v := range variables {
+			variable, _ := v.(map[string]interface{})
+			if def, ok := variable["default"].(string); ok {
+				serverURL = strings.ReplaceAll(serverURL, "{"+name+"}", def)
+			}
+		}
+
+		prefix := serverURL
+		if u, err := url.Parse(serverURL); err == nil && (u.Scheme != "" || strings.HasPrefix(serverURL, "//")) {
+			prefix = u.Path
+		}
+		prefix = strings.TrimRight(prefix, "/")
+
+		if !seen[prefix] {
+			seen[prefix] = true
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// RequestBodySchema returns the JSON schema for the operation's request body.
+func (doc *OpenAPIDocument) RequestBodySchema(op *OpenAPIOperation) map[string]interface{} {
+	body := doc.resolve(op.Node["requestBody"])
+	if body == nil {
+		return nil
+	}
+	_, media := doc.jsonMediaType(body["content"])
+	if media == nil {
+		return nil
+	}
+	return doc.resolve(media["schema"])
+}
+
+// OpenAPIResponse is one example response of an operation.
+type OpenAPIResponse struct {
+	Status      int
+	ContentType string
+	Body        interface{}
+	Headers     map[string]string
+	Description string
+}
+
+// Responses returns one example response per documented status code.
+// "default" is mapped to 500 and range codes such as "4XX" to their first
+// code, unless that code is also documented explicitly.
+func (doc *OpenAPIDocument) Responses(op *OpenAPIOperation) []OpenAPIResponse {
+	responses, _ := op.Node["responses"].(map[string]interface{})
+
+	explicit := make(map[int]bool)
+	for code := range responses {
+		if status, err := strconv.Atoi(code); err == nil {
+			explicit[status] = true
+		}
+	}
+
+	var result []OpenAPIResponse
+	for code, raw := range responses {
+		status, err := strconv.Atoi(code)
+		if err != nil {
+			upper := strings.ToUpper(code)
+			switch {
+			case upper == "DEFAULT":
+				status = http.StatusInternalServerError
+			case len(upper) == 3 && strings.HasSuffix(upper, "XX") && upper[0] >= '1' && upper[0] <= '5':
+				status = int(upper[0]-'0') * 100
+			default:
+				continue
+			}
+			if explicit[status] {
+				continue
+			}
+		}
+
+		response := doc.resolve(raw)
+		if response == nil {
+			continue
+		}
+
+		r := OpenAPIResponse{Status: status, Headers: make(map[string]string)}
+		r.Description, _ = response["description"].(string)
+
+		if contentType, media := doc.jsonMediaType(response["content"]); media != nil {
+			r.ContentType = contentType
+			r.Body = doc.mediaExample(media)
+			r.Headers["Content-Type"] = contentType
+		}
+
+		headers, _ := response["headers"].(map[string]interface{})
+		for name, h := range headers {
+			header := doc.resolve(h)
+			if header == nil {
+				continue
+			}
+			value := header["example"]
+			if value == nil {
+				value = doc.SynthesizeExample(header["schema"])
+			}
+			if value != nil {
+				r.Headers[name] = fmt.Sprint(value)
+			}
+		}
+
+		result = append(result, r)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Status < result[j].Status })
+	return result
+}
+
+// jsonMediaType picks the most useful media type from a content map,
+// preferring JSON.
+func (doc *OpenAPIDocument) jsonMediaType(node interface{}) (string, map[string]interface{}) {
+	content, _ := node.(map[string]interface{})
+	if len(content) == 0 {
+		return "", nil
+	}
+
+	types := make([]string, 0, len(content))
+	for contentType := range content {
+		types = append(types, contentType)
+	}
+	sort.Strings(types)
+
+	chosen := types[0]
+	for _, contentType := range types {
+		if contentType == "application/json" || strings.HasSuffix(contentType, "+json") {
+			chosen = contentType
+			break
+		}
+	}
+	media, _ := content[chosen].(map[string]interface{})
+	if media == nil {
+		media = map[string]interface{}{}
+	}
+	return chosen, media
+}
+
+func (doc *OpenAPIDocument) mediaExample(media map[string]interface{}) interface{} {
+	if example, ok := media["example"]; ok {
+		return example
+	}
+
+	if examples, ok := media["examples"].(map[string]interface{}); ok && len(examples) > 0 {
+		names := make([]string, 0, len(examples))
+		for name := range examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if example := doc.resolve(examples[names[0]]); example != nil {
+			if value, ok := example["value"]; ok {
+				return value
+			}
+		}
+	}
+
+	return doc.SynthesizeExample(media["schema"])
+}
+
+// SynthesizeExample builds a plausible value from a schema, using examples,
+// defaults and enums where the schema provides them.
+func (doc *OpenAPIDocument) SynthesizeExample(node interface{}) interface{} {
+	return doc.synthesize(node, 0)
+}
+
+func (doc *OpenAPIDocument) synthesize(node interface{}, depth int) interface{} {
+	schema := doc.resolve(node)
+	if schema == nil || depth > 8 {
+		return nil
+	}
+
+	if example, ok := schema["example"]; ok {
+		return example
+	}
+	if examples, ok := schema["examples"].([]interface{}); ok && len(examples) > 0 {
+		return examples[0]
+	}
+	if def, ok := schema["default"]; ok {
+		return def
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	if constant, ok := schema["const"]; ok {
+		return constant
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		merged := make(map[string]interface{})
+		var last interface{}
+		for _, sub := range allOf {
+			value := doc.synthesize(sub, depth+1)
+			if obj, ok := value.(map[string]interface{}); ok {
+				for k, v := range obj {
+					merged[k] = v
+				}
+			} else if value != nil {
+				last = value
+			}
+		}
+		if props, ok := schema["properties"].(map[string]interface{}); ok {
+			for name, prop := range props {
+				merged[name] = doc.synthesize(prop, depth+1)
+			}
+		}
+		if len(merged) > 0 || last == nil {
+			return merged
+		}
+		return last
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if options, ok := schema[key].([]interface{}); ok && len(options) > 0 {
+			return doc.synthesize(options[0], depth+1)
+		}
+	}
+
+	schemaType := schemaTypeOf(schema)
+	switch schemaType {
+	case "object":
+		obj := make(map[string]interface{})
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, prop := range props {
+			obj[name] = doc.synthesize(prop, depth+1)
+		}
+		return obj
+	case "array":
+		item := doc.synthesize(schema["items"], depth+1)
+		if item == nil {
+			return []interface{}{}
+		}
+		return []interface{}{item}
+	case "integer":
+		if min, ok := schema["minimum"].(float64); ok {
+			return int64(min)
+		}
+		return 0
+	case "number":
+		if min, ok := schema["minimum"].(float64); ok {
+			return min
+		}
+		return 0.0
+	case "boolean":
+		return true
+	case "string":
+		return exampleString(schema)
+	}
+	return nil
+}
+
+// schemaTypeOf returns the schema's type, inferring "object" or "array" from
+// properties/items when no type is declared.
+func schemaTypeOf(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok && s != "null" {
+				return s
+			}
+		}
+	}
+	if _, ok := schema["properties"]; ok {
+		return "object"
+	}
+	if _, ok := schema["items"]; ok {
+		return "array"
+	}
+	return ""
+}
+
+func exampleString(schema map[string]interface{}) string {
+	format, _ := schema["format"].(string)
+	switch format {
+	case "date-time":
+		return "2024-01-01T00:00:00Z"
+	case "date":
+		return "2024-01-01"
+	case "time":
+		return "00:00:00Z"
+	case "uuid":
+		return "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+	case "email":
+		return "user@example.com"
+	case "uri", "url":
+		return "https://example.com"
+	case "hostname":
+		return "example.com"
+	case "ipv4":
+		return "192.0.2.1"
+	case "ipv6":
+		return "2001:db8::1"
+	case "byte":
+		return "c3RyaW5n"
+	}
+
+	value := "string"
+	if minLen, ok := schema["minLength"].(float64); ok && int(minLen) > len(value) {
+		value += strings.Repeat("x", int(minLen)-len(value))
+	}
+	return value
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeSpec(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const yamlSpec = `openapi: 3.0.3
+info: {title: Accounts, version: "1"}
+paths:
+  /accounts/{id}:
+    get:
+      responses:
+        200:
+          content:
+            application/json:
+              schema: {$ref: '#/components/schemas/Account'}
+components:
+  schemas:
+    Base: &base
+      type: object
+    Account:
+      <<: *base
+      properties:
+        id: {type: integer, example: 42}
+        opened: {type: string, format: date, example: 2024-01-02}
+`
+
+func TestLoadOpenAPIDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr bool
+	}{
+		{"yaml", "api.yaml", yamlSpec, false},
+		{"json", "api.json", `{"openapi": "3.1.0", "paths": {}}`, false},
+		{"swagger 2", "api.yaml", "swagger: \"2.0\"\npaths: {}\n", true},
+		{"yaml list", "api.yaml", "- openapi\n", true},
+		{"neither", "api.txt", "openapi: [3.0\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadOpenAPIDocument(writeSpec(t, tt.file, tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadOpenAPIDocumentYAMLValues(t *testing.T) {
+	doc, err := LoadOpenAPIDocument(writeSpec(t, "api.yaml", yamlSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := doc.lookup("#/paths/~1accounts~1{id}/get/responses/200")
+	if response == nil {
+		t.Fatal("unquoted 200 response key not found")
+	}
+	schema := response.(map[string]interface{})["content"].(map[string]interface{})["application/json"].(map[string]interface{})["schema"]
+	account := doc.resolve(schema)
+	if account["type"] != "object" {
+		t.Errorf("merge key not applied: %v", account)
+	}
+	properties := account["properties"].(map[string]interface{})
+	if got := properties["id"].(map[string]interface{})["example"]; got != float64(42) {
+		t.Errorf("id example = %#v, want float64 42", got)
+	}
+	if got := properties["opened"].(map[string]interface{})["example"]; got != "2024-01-02" {
+		t.Errorf("date example = %#v, want the text as written", got)
+	}
+}
+
+func TestOpenAPIDocumentLookup(t *testing.T) {
+	doc := &OpenAPIDocument{root: map[string]interface{}{
+		"paths": map[string]interface{}{"/a/b": "slashes", "/a~1b": "literal", "~x": "tilde", "a b": "space"},
+		"list":  []interface{}{"zero"},
+	}}
+	tests := []struct {
+		ref  string
+		want interface{}
+	}{
+		{"#/paths/~1a~1b", "slashes"},
+		{"#/paths/~1a%7E1b", "slashes"},
+		{"#/paths/~1a~01b", "literal"},
+		{"#/paths/~0x", "tilde"},
+		{"#/paths/a%20b", "space"},
+		{"#/paths/missing", nil},
+		{"#/list/0", nil},
+		{"other.yaml#/paths", nil},
+		{"#/paths/%zz", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			if got := doc.lookup(tt.ref); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup(%q) = %#v, want %#v", tt.ref, got, tt.want)
+			}
+		})
+	}
+}
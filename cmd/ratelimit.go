@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RateLimitConfig is a token bucket: Requests per WindowSeconds, with up to
+// Burst (default Requests) available at once. Key partitions the bucket:
+// "" shares it between all clients, "ip" gives each client address its own
+// and "header" each value of Header (e.g. X-API-Key). Exceeding the limit
+// returns Status (429) with Response, Headers and Retry-After.
+type RateLimitConfig struct {
+	Requests      int               `json:"requests"`
+	WindowSeconds float64           `json:"window_seconds"`
+	Burst         int               `json:"burst,omitempty"`
+	Key           string            `json:"key,omitempty"`
+	Header        string            `json:"header,omitempty"`
+	Status        int               `json:"status,omitempty"`
+	Response      interface{}       `json:"response,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+}
+
+func (config *RateLimitConfig) validate() error {
+	if config.Requests <= 0 || config.WindowSeconds <= 0 {
+		return fmt.Errorf("rate limit needs positive requests and window_seconds")
+	}
+	if config.Burst < 0 {
+		return fmt.Errorf("invalid burst %d", config.Burst)
+	}
+	switch config.Key {
+	case "", "ip":
+	case "header":
+		if config.Header == "" {
+			return fmt.Errorf("rate limit keyed by header needs a header name")
+		}
+	default:
+		return fmt.Errorf("invalid rate limit key %q (want ip or header)", config.Key)
+	}
+	return nil
+}
+
+func (config *RateLimitConfig) capacity() float64 {
+	if config.Burst > 0 {
+		return float64(config.Burst)
+	}
+	return float64(config.Requests)
+}
+
+// rate is the refill rate in tokens per second.
+func (config *RateLimitConfig) rate() float64 {
+	return float64(config.Requests) / config.WindowSeconds
+}
+
+// LoadRateLimitConfig reads the global limit applied to every route without
+// its own "rate_limit".
+func LoadRateLimitConfig(path string) (*RateLimitConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config RateLimitConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &config, config.validate()
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled to capacity
+	full time.Time
+}
+
+// bucketSweepInterval is how often take drops buckets that have refilled.
+// A full bucket behaves exactly like a missing one, so per-client buckets
+// don't accumulate for clients that have gone away.
+const bucketSweepInterval = time.Minute
+
+// rateLimiter holds the buckets of every policy and client.
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// take spends a token from the bucket for key. It returns whether the
+// request is allowed, the whole tokens left, and how long until the next
+// token and until the bucket is full again.
+func (l *rateLimiter) take(key string, config *RateLimitConfig, now time.Time) (allowed bool, remaining int, retryAfter, reset time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= bucketSweepInterval {
+		for name, bucket := range l.buckets {
+			if !bucket.full.After(now) {
+				delete(l.buckets, name)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	capacity, rate := config.capacity(), config.rate()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		allowed = true
+	} else {
+		retryAfter = time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	}
+	reset = time.Duration((capacity - bucket.tokens) / rate * float64(time.Second))
+	bucket.full = now.Add(reset)
+	return allowed, int(bucket.tokens), retryAfter, reset
+}
+
+func (l *rateLimiter) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buckets = make(map[string]*tokenBucket)
+}
+
+// checkRateLimit applies the route's limit, or the global one, to the
+// request. It returns the X-RateLimit headers to send, which take precedence
+// over any recorded with the route, and reports false after writing the
+// limited response.
+func (ms *MockServer) checkRateLimit(c echo.Context, key string, route *RouteConfig) (http.Header, bool, error) {
+	config, bucketKey := route.RateLimit, "route "+key
+	if config == nil {
+		config, bucketKey = ms.rateLimit, "global"
+	}
+	if config == nil {
+		return nil, true, nil
+	}
+
+	switch config.Key {
+	case "ip":
+		bucketKey += " ip " + c.RealIP()
+	case "header":
+		bucketKey += " header " + c.Request().Header.Get(config.Header)
+	}
+
+	allowed, remaining, retryAfter, reset := ms.limiter.take(bucketKey, config, time.Now())
+	limitHeaders := http.Header{}
+	limitHeaders.Set("X-RateLimit-Limit", strconv.Itoa(config.Requests))
+	limitHeaders.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	limitHeaders.Set("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+	if allowed {
+		return limitHeaders, true, nil
+	}
+
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	header := c.Response().Header()
+	for name, values := range limitHeaders {
+		header[name] = values
+	}
+	header.Set("Retry-After", strconv.Itoa(seconds))
+	for name, value := range config.Headers {
+		header.Set(name, value)
+	}
+	log.Printf("🚦 Rate limit exceeded for %s (retry after %ds)", bucketKey, seconds)
+
+	status := config.Status
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	response := config.Response
+	if response == nil {
+		response = map[string]interface{}{
+			"error":       "Too Many Requests",
+			"message":     "Rate limit exceeded",
+			"retry_after": seconds,
+		}
+	}
+	return nil, false, c.JSON(status, response)
+}
+
+// handleResetRateLimits refills every bucket.
+func (ms *MockServer) handleResetRateLimits(c echo.Context) error {
+	ms.limiter.reset()
+	log.Println("🚦 Rate limits reset")
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterTake(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	type step struct {
+		after     time.Duration
+		allowed   bool
+		remaining int
+	}
+	tests := []struct {
+		name   string
+		config RateLimitConfig
+		steps  []step
+	}{
+		{
+			name:   "spends the burst then refuses",
+			config: RateLimitConfig{Requests: 2, WindowSeconds: 1},
+			steps:  []step{{0, true, 1}, {0, true, 0}, {0, false, 0}},
+		},
+		{
+			name:   "refills at requests per window",
+			config: RateLimitConfig{Requests: 2, WindowSeconds: 1},
+			steps:  []step{{0, true, 1}, {0, true, 0}, {500 * time.Millisecond, true, 0}, {0, false, 0}},
+		},
+		{
+			name:   "burst above the rate",
+			config: RateLimitConfig{Requests: 1, WindowSeconds: 10, Burst: 3},
+			steps:  []step{{0, true, 2}, {0, true, 1}, {0, true, 0}, {0, false, 0}, {10 * time.Second, true, 0}},
+		},
+		{
+			name:   "never exceeds capacity",
+			config: RateLimitConfig{Requests: 2, WindowSeconds: 1},
+			steps:  []step{{0, true, 1}, {time.Hour, true, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newRateLimiter()
+			now := start
+			for i, s := range tt.steps {
+				now = now.Add(s.after)
+				allowed, remaining, _, _ := limiter.take("key", &tt.config, now)
+				if allowed != s.allowed || remaining != s.remaining {
+					t.Fatalf("step %d: take = (%v, %d), want (%v, %d)", i, allowed, remaining, s.allowed, s.remaining)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterRetryAfterAndReset(t *testing.T) {
+	limiter := newRateLimiter()
+	config := &RateLimitConfig{Requests: 1, WindowSeconds: 4}
+	now := time.Unix(1700000000, 0)
+
+	if allowed, _, _, reset := limiter.take("key", config, now); !allowed || reset != 4*time.Second {
+		t.Fatalf("first take: allowed=%v reset=%v", allowed, reset)
+	}
+	allowed, _, retryAfter, _ := limiter.take("key", config, now.Add(time.Second))
+	if allowed || retryAfter != 3*time.Second {
+		t.Fatalf("second take: allowed=%v retryAfter=%v, want refused after 3s", allowed, retryAfter)
+	}
+}
+
+func TestRateLimiterKeysAreIndependent(t *testing.T) {
+	limiter := newRateLimiter()
+	config := &RateLimitConfig{Requests: 1, WindowSeconds: 60}
+	now := time.Unix(1700000000, 0)
+	if allowed, _, _, _ := limiter.take("a", config, now); !allowed {
+		t.Fatal("a refused")
+	}
+	if allowed, _, _, _ := limiter.take("b", config, now); !allowed {
+		t.Fatal("b refused after a spent its token")
+	}
+}
+
+func TestRateLimiterDropsRefilledBuckets(t *testing.T) {
+	limiter := newRateLimiter()
+	config := &RateLimitConfig{Requests: 1, WindowSeconds: 1}
+	slow := &RateLimitConfig{Requests: 1, WindowSeconds: 3600}
+	now := time.Unix(1700000000, 0)
+
+	for _, key := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		limiter.take(key, config, now)
+	}
+	limiter.take("slow", slow, now)
+
+	limiter.take("10.0.0.4", config, now.Add(bucketSweepInterval))
+	if _, ok := limiter.buckets["10.0.0.1"]; ok {
+		t.Error("refilled bucket was kept")
+	}
+	if _, ok := limiter.buckets["slow"]; !ok {
+		t.Error("bucket still refilling was dropped")
+	}
+	if len(limiter.buckets) != 2 {
+		t.Errorf("%d buckets left, want 2", len(limiter.buckets))
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"firecracker/mock-api-server/internal/resourceid"
+)
+
+// ResourceConfig turns a route into an in-memory collection. The route's
+// path is the collection (e.g. "/customers") and path + "/{id}" its items:
+//
+//	GET    /customers       list, filtered by query parameters and paged
+//	POST   /customers       create (201), generating the ID unless given
+//	GET    /customers/{id}  read
+//	PUT    /customers/{id}  replace
+//	PATCH  /customers/{id}  merge
+//	DELETE /customers/{id}  delete (204)
+//
+// Items are seeded from Items and/or the Seed file (a JSON array, or an
+// object holding one under ListKey). IDType is "int" or "uuid" (optionally
+// with IDPrefix), inferred from the seeds when empty. Lists are a bare array
+// with X-Total-Count unless ListKey is set, in which case they are wrapped as
+// {ListKey: [...], "total", "limit", "offset"}. Query parameters naming a
+// field some item has filter the list, or only those in FilterFields when it
+// is set; others (sort, include, ...) are ignored. Path parameters of the
+// collection path scope the items to fields of the same name.
+type ResourceConfig struct {
+	Seed         string                   `json:"seed,omitempty"`
+	Items        []map[string]interface{} `json:"items,omitempty"`
+	IDField      string                   `json:"id_field,omitempty"`
+	IDType       string                   `json:"id_type,omitempty"`
+	IDPrefix     string                   `json:"id_prefix,omitempty"`
+	ListKey      string                   `json:"list_key,omitempty"`
+	PageSize     int                      `json:"page_size,omitempty"`
+	FilterFields []string                 `json:"filter_fields,omitempty"`
+}
+
+func (config *ResourceConfig) validate() error {
+	switch config.IDType {
+	case "", "int", "uuid":
+	default:
+		return fmt.Errorf("invalid id_type %q (want int or uuid)", config.IDType)
+	}
+	if config.PageSize < 0 {
+		return fmt.Errorf("invalid page_size %d", config.PageSize)
+	}
+	return nil
+}
+
+func (config *ResourceConfig) idField() string {
+	if config.IDField == "" {
+		return "id"
+	}
+	return config.IDField
+}
+
+// resourceStore holds a resource's items in insertion order.
+type resourceStore struct {
+	config    ResourceConfig
+	signature string
+	seed      []map[string]interface{}
+	mu        sync.Mutex
+	items     []map[string]interface{}
+	nextID    int
+}
+
+// newResourceStore loads the seeds of config; relative seed paths are
+// resolved against baseDir.
+func newResourceStore(config ResourceConfig, baseDir string) (*resourceStore, error) {
+	seed := append([]map[string]interface{}{}, config.Items...)
+	if config.Seed != "" {
+		data, err := os.ReadFile(resolveConfigPath(baseDir, config.Seed))
+		if err != nil {
+			return nil, err
+		}
+		items, err := parseSeed(data, config.ListKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse seed %s: %w", config.Seed, err)
+		}
+		seed = append(seed, items...)
+	}
+
+	if config.IDType == "" {
+		config.IDType = "uuid"
+		if len(seed) > 0 {
+			if _, numeric := seed[0][config.idField()].(float64); numeric {
+				config.IDType = "int"
+			}
+		}
+	}
+
+	// Seed edits change the signature too, so they reseed on reload
+	signature, _ := json.Marshal(config)
+	seedData, _ := json.Marshal(seed)
+	seedHash := sha256.Sum256(seedData)
+	store := &resourceStore{
+		config:    config,
+		signature: string(signature) + hex.EncodeToString(seedHash[:]),
+		seed:      seed,
+	}
+	store.reset()
+	return store, nil
+}
+
+// parseSeed accepts a JSON array of items or an object with the items under
+// listKey.
+func parseSeed(data []byte, listKey string) ([]map[string]interface{}, error) {
+	var items []map[string]interface{}
+	if err := json.Unmarshal(data, &items); err == nil {
+		return items, nil
+	}
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return nil, err
+	}
+	if listKey == "" {
+		return nil, fmt.Errorf("seed is an object but the resource has no list_key")
+	}
+	if err := json.Unmarshal(wrapped[listKey], &items); err != nil {
+		return nil, fmt.Errorf("%q: %w", listKey, err)
+	}
+	return items, nil
+}
+
+// reset restores the seeded items.
+func (s *resourceStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items = make([]map[string]interface{}, 0, len(s.seed))
+	s.nextID = 1
+	for _, item := range s.seed {
+		s.items = append(s.items, copyItem(item))
+		if id, ok := item[s.config.idField()].(float64); ok && int(id) >= s.nextID {
+			s.nextID = int(id) + 1
+		}
+	}
+}
+
+// copyItem deep-copies an item so callers can't change the store.
+func copyItem(item map[string]interface{}) map[string]interface{} {
+	data, _ := json.Marshal(item)
+	var copied map[string]interface{}
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+func (s *resourceStore) newID() interface{} {
+	if s.config.IDType == "int" {
+		id := s.nextID
+		s.nextID++
+		return float64(id)
+	}
+	return s.config.IDPrefix + newUUID()
+}
+
+// inScope reports whether item belongs under the collection path
+// parameters; an item without the field belongs to no scope.
+func inScope(item map[string]interface{}, scope map[string]string) bool {
+	for name, value := range scope {
+		if field, ok := item[name]; !ok || resourceid.Format(field) != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *resourceStore) find(id string, scope map[string]string) int {
+	for i, item := range s.items {
+		if resourceid.Format(item[s.config.idField()]) == id && inScope(item, scope) {
+			return i
+		}
+	}
+	return -1
+}
+
+// resourceQueryParams control paging rather than filter the list.
+var resourceQueryParams = map[string]bool{"limit": true, "offset": true, "page": true, "per_page": true}
+
+// filters picks the query parameters that filter the list: those in
+// FilterFields, or else those naming a field of at least one item.
+func (s *resourceStore) filters(query map[string][]string) map[string]string {
+	filters := make(map[string]string)
+	for name, values := range query {
+		if resourceQueryParams[name] || len(values) == 0 {
+			continue
+		}
+		if s.config.FilterFields != nil {
+			for _, field := range s.config.FilterFields {
+				if field == name {
+					filters[name] = values[0]
+				}
+			}
+			continue
+		}
+		for _, item := range s.items {
+			if _, ok := lookupField(item, strings.Split(name, ".")); ok {
+				filters[name] = values[0]
+				break
+			}
+		}
+	}
+	return filters
+}
+
+// list returns the items matching the query's filters (field=value, with
+// dotted names for nested fields) and the requested page.
+func (s *resourceStore) list(query map[string][]string, scope map[string]string) (status int, response interface{}, total int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filters := s.filters(query)
+	matched := make([]interface{}, 0)
+	for _, item := range s.items {
+		if !inScope(item, scope) {
+			continue
+		}
+		keep := true
+		for name, want := range filters {
+			value, ok := lookupField(item, strings.Split(name, "."))
+			if !ok || resourceid.Format(value) != want {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			matched = append(matched, copyItem(item))
+		}
+	}
+
+	intParam := func(name string) (int, error) {
+		values := query[name]
+		if len(values) == 0 || values[0] == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(values[0])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s %q", name, values[0])
+		}
+		return n, nil
+	}
+	limit, err := intParam("limit")
+	if err == nil && limit == 0 {
+		limit, err = intParam("per_page")
+	}
+	offset, offsetErr := intParam("offset")
+	page, pageErr := intParam("page")
+	for _, e := range []error{err, offsetErr, pageErr} {
+		if e != nil {
+			return http.StatusBadRequest, map[string]string{"error": e.Error()}, 0
+		}
+	}
+	if limit == 0 {
+		limit = s.config.PageSize
+	}
+	if page > 0 && limit > 0 {
+		offset = (page - 1) * limit
+	}
+
+	total = len(matched)
+	if offset > total {
+		offset = total
+	}
+	pageItems := matched[offset:]
+	if limit > 0 && len(pageItems) > limit {
+		pageItems = pageItems[:limit]
+	}
+
+	if s.config.ListKey == "" {
+		return http.StatusOK, pageItems, total
+	}
+	return http.StatusOK, map[string]interface{}{
+		s.config.ListKey: pageItems,
+		"total":          total,
+		"limit":          limit,
+		"offset":         offset,
+	}, total
+}
+
+func (s *resourceStore) get(id string, scope map[string]string) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id, scope)
+	if i < 0 {
+		return http.StatusNotFound, resourceNotFound(id)
+	}
+	return http.StatusOK, copyItem(s.items[i])
+}
+
+func (s *resourceStore) create(item map[string]interface{}, scope map[string]string) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idField := s.config.idField()
+	for name, value := range scope {
+		if _, ok := item[name]; !ok {
+			item[name] = value
+		}
+	}
+	if id, ok := item[idField]; ok && id != nil {
+		if s.find(resourceid.Format(id), nil) >= 0 {
+			return http.StatusConflict, map[string]string{"error": "Resource already exists", "id": resourceid.Format(id)}
+		}
+		if n, numeric := id.(float64); numeric && int(n) >= s.nextID {
+			s.nextID = int(n) + 1
+		}
+	} else {
+		item[idField] = s.newID()
+	}
+	s.items = append(s.items, item)
+	return http.StatusCreated, copyItem(item)
+}
+
+// update replaces the item, or with merge (PATCH) overwrites only the fields
+// given. The ID cannot change.
+func (s *resourceStore) update(id string, changes map[string]interface{}, merge bool, scope map[string]string) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id, scope)
+	if i < 0 {
+		return http.StatusNotFound, resourceNotFound(id)
+	}
+	idField := s.config.idField()
+	originalID := s.items[i][idField]
+	item := changes
+	if merge {
+		item = s.items[i]
+		for name, value := range changes {
+			item[name] = value
+		}
+	}
+	item[idField] = originalID
+	for name, value := range scope {
+		if _, ok := item[name]; !ok {
+			item[name] = value
+		}
+	}
+	s.items[i] = item
+	return http.StatusOK, copyItem(item)
+}
+
+func (s *resourceStore) remove(id string, scope map[string]string) (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.find(id, scope)
+	if i < 0 {
+		return http.StatusNotFound, resourceNotFound(id)
+	}
+	s.items = append(s.items[:i], s.items[i+1:]...)
+	return http.StatusNoContent, nil
+}
+
+func (s *resourceStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items)
+}
+
+func resourceNotFound(id string) map[string]string {
+	return map[string]string{"error": "Resource not found", "id": id}
+}
+
+// matchResource finds the resource route serving path, returning the
+// collection path parameters and the item ID ("" for the collection).
+func (ms *MockServer) matchResource(path string) (*RouteConfig, map[string]string, string, bool) {
+	for _, route := range ms.resourceRoutes {
+		route := route
+		if params, ok := matchPath(route.Path, path); ok {
+			return &route, params, "", true
+		}
+		if i := strings.LastIndex(path, "/"); i > 0 && i < len(path)-1 {
+			if params, ok := matchPath(route.Path, path[:i]); ok {
+				return &route, params, path[i+1:], true
+			}
+		}
+	}
+	return nil, nil, "", false
+}
+
+// serveResource runs a request against a resource route's store and
+// returns the status and body to send.
+func serveResource(c echo.Context, store *resourceStore, scope map[string]string, id string) (int, interface{}) {
+	r := c.Request()
+
+	var body map[string]interface{}
+	if r.Method == http.MethodPost || r.Method == http.MethodPut || r.Method == http.MethodPatch {
+		var data []byte
+		if r.Body != nil {
+			data, _ = io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewReader(data))
+		}
+		if err := json.Unmarshal(data, &body); err != nil || body == nil {
+			return http.StatusBadRequest, map[string]string{"error": "Request body must be a JSON object"}
+		}
+	}
+
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			status, response, total := store.list(r.URL.Query(), scope)
+			if store.config.ListKey == "" && status == http.StatusOK {
+				c.Response().Header().Set("X-Total-Count", strconv.Itoa(total))
+			}
+			return status, response
+		case http.MethodPost:
+			return store.create(body, scope)
+		}
+	} else {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			return store.get(id, scope)
+		case http.MethodPut:
+			return store.update(id, body, false, scope)
+		case http.MethodPatch:
+			return store.update(id, body, true, scope)
+		case http.MethodDelete:
+			return store.remove(id, scope)
+		}
+	}
+	return http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed on this resource"}
+}
+
+// handleListResources reports each resource and how many items it holds.
+func (ms *MockServer) handleListResources(c echo.Context) error {
+	ms.routesMu.RLock()
+	defer ms.routesMu.RUnlock()
+	resources := make([]map[string]interface{}, 0, len(ms.resourceRoutes))
+	for _, route := range ms.resourceRoutes {
+		resources = append(resources, map[string]interface{}{
+			"path":  route.Path,
+			"count": ms.resources[route.Path].count(),
+		})
+	}
+	return c.JSON(http.StatusOK, resources)
+}
+
+// handleResetResources restores every resource, or the one at ?path=, to
+// its seed.
+func (ms *MockServer) handleResetResources(c echo.Context) error {
+	ms.routesMu.RLock()
+	defer ms.routesMu.RUnlock()
+	path := c.QueryParam("path")
+	if path != "" {
+		store, ok := ms.resources[path]
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Resource not found", "path": path})
+		}
+		store.reset()
+		log.Printf("📦 Resource %s reset", path)
+		return c.NoContent(http.StatusNoContent)
+	}
+	for _, store := range ms.resources {
+		store.reset()
+	}
+	log.Println("📦 Resources reset")
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"firecracker/mock-api-server/internal/resourceid"
+)
+
+func newTestStore(t *testing.T, config ResourceConfig) *resourceStore {
+	t.Helper()
+	store, err := newResourceStore(config, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func customerSeed() []map[string]interface{} {
+	return []map[string]interface{}{
+		{"id": float64(1), "name": "Ada", "status": "active", "account_id": "a1", "address": map[string]interface{}{"country": "GB"}},
+		{"id": float64(2), "name": "Grace", "status": "blocked", "account_id": "a1", "address": map[string]interface{}{"country": "US"}},
+		{"id": float64(3), "name": "Linus", "status": "active", "account_id": "a2", "address": map[string]interface{}{"country": "FI"}},
+		{"id": float64(4), "name": "Barbara", "status": "active", "account_id": "a2", "address": map[string]interface{}{"country": "US"}},
+	}
+}
+
+// listIDs returns the IDs of a list response, bare or wrapped under listKey.
+func listIDs(t *testing.T, response interface{}, listKey string) string {
+	t.Helper()
+	items, ok := response.([]interface{})
+	if listKey != "" {
+		items, ok = response.(map[string]interface{})[listKey].([]interface{})
+	}
+	if !ok {
+		t.Fatalf("unexpected list response %#v", response)
+	}
+	ids := make([]string, len(items))
+	for i, item := range items {
+		ids[i] = resourceid.Format(item.(map[string]interface{})["id"])
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestResourceStoreCRUD(t *testing.T) {
+	store := newTestStore(t, ResourceConfig{Items: customerSeed()})
+
+	status, created := store.create(map[string]interface{}{"name": "Margaret"}, nil)
+	if status != http.StatusCreated || created.(map[string]interface{})["id"] != float64(5) {
+		t.Fatalf("create = %d %v, want 201 with id 5", status, created)
+	}
+	if status, _ := store.create(map[string]interface{}{"id": float64(5)}, nil); status != http.StatusConflict {
+		t.Errorf("duplicate create = %d, want 409", status)
+	}
+
+	if status, item := store.get("5", nil); status != http.StatusOK || item.(map[string]interface{})["name"] != "Margaret" {
+		t.Errorf("get = %d %v", status, item)
+	}
+
+	status, replaced := store.update("5", map[string]interface{}{"id": float64(99), "status": "active"}, false, nil)
+	if status != http.StatusOK {
+		t.Fatalf("put = %d", status)
+	}
+	if item := replaced.(map[string]interface{}); item["id"] != float64(5) || item["name"] != nil || item["status"] != "active" {
+		t.Errorf("put kept %v, want the id and only the new fields", item)
+	}
+
+	status, merged := store.update("5", map[string]interface{}{"name": "Margaret H"}, true, nil)
+	if item := merged.(map[string]interface{}); status != http.StatusOK || item["name"] != "Margaret H" || item["status"] != "active" {
+		t.Errorf("patch = %d %v, want both fields", status, item)
+	}
+
+	if status, _ := store.remove("5", nil); status != http.StatusNoContent {
+		t.Errorf("delete = %d, want 204", status)
+	}
+	for name, status := range map[string]int{
+		"get":    first(store.get("5", nil)),
+		"put":    first(store.update("5", map[string]interface{}{}, false, nil)),
+		"delete": first(store.remove("5", nil)),
+	} {
+		if status != http.StatusNotFound {
+			t.Errorf("%s after delete = %d, want 404", name, status)
+		}
+	}
+
+	store.reset()
+	if n := store.count(); n != 4 {
+		t.Errorf("count after reset = %d, want 4", n)
+	}
+}
+
+func first(status int, _ interface{}) int {
+	return status
+}
+
+func TestResourceStoreGeneratesIDs(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ResourceConfig
+		want   string // prefix of the generated ID, or the exact int
+	}{
+		{"int inferred from seeds", ResourceConfig{Items: customerSeed()}, "5"},
+		{"int without seeds", ResourceConfig{IDType: "int"}, "1"},
+		{"uuid with prefix", ResourceConfig{IDType: "uuid", IDPrefix: "cus_"}, "cus_"},
+		{"uuid inferred without seeds", ResourceConfig{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, tt.config)
+			_, created := store.create(map[string]interface{}{}, nil)
+			id := resourceid.Format(created.(map[string]interface{})["id"])
+			if store.config.IDType == "int" {
+				if id != tt.want {
+					t.Errorf("id = %q, want %q", id, tt.want)
+				}
+			} else if !strings.HasPrefix(id, tt.want) || len(id) != len(tt.want)+36 {
+				t.Errorf("id = %q, want %q and a UUID", id, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceStoreList(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  ResourceConfig
+		query   string
+		want    string
+		total   int
+		wantErr bool
+	}{
+		{name: "everything", query: "", want: "1,2,3,4", total: 4},
+		{name: "filter", query: "status=active", want: "1,3,4", total: 3},
+		{name: "nested filter", query: "address.country=US", want: "2,4", total: 2},
+		{name: "numeric filter", query: "id=3", want: "3", total: 1},
+		{name: "unknown params are ignored", query: "sort=-name&include=cards", want: "1,2,3,4", total: 4},
+		{name: "filter_fields limits filters", config: ResourceConfig{FilterFields: []string{"status"}}, query: "status=active&name=Ada", want: "1,3,4", total: 3},
+		{name: "limit and offset", query: "limit=2&offset=1", want: "2,3", total: 4},
+		{name: "page and per_page", query: "page=2&per_page=3", want: "4", total: 4},
+		{name: "offset past the end", query: "offset=10", want: "", total: 4},
+		{name: "page_size default", config: ResourceConfig{PageSize: 3}, query: "", want: "1,2,3", total: 4},
+		{name: "filter then page", query: "status=active&limit=1&offset=1", want: "3", total: 3},
+		{name: "invalid limit", query: "limit=-1", wantErr: true},
+		{name: "invalid page", query: "page=two", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Items = customerSeed()
+			store := newTestStore(t, config)
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			status, response, total := store.list(query, nil)
+			if tt.wantErr {
+				if status != http.StatusBadRequest {
+					t.Errorf("status = %d, want 400", status)
+				}
+				return
+			}
+			if status != http.StatusOK {
+				t.Fatalf("status = %d: %v", status, response)
+			}
+			if got := listIDs(t, response, ""); got != tt.want {
+				t.Errorf("ids = %q, want %q", got, tt.want)
+			}
+			if total != tt.total {
+				t.Errorf("total = %d, want %d", total, tt.total)
+			}
+		})
+	}
+}
+
+func TestResourceStoreListKey(t *testing.T) {
+	store := newTestStore(t, ResourceConfig{Items: customerSeed(), ListKey: "data"})
+	_, response, _ := store.list(url.Values{"limit": {"2"}, "offset": {"2"}}, nil)
+	if got := listIDs(t, response, "data"); got != "3,4" {
+		t.Errorf("ids = %q, want 3,4", got)
+	}
+	wrapped := response.(map[string]interface{})
+	if wrapped["total"] != 4 || wrapped["limit"] != 2 || wrapped["offset"] != 2 {
+		t.Errorf("envelope = %v", wrapped)
+	}
+}
+
+func TestResourceStoreScope(t *testing.T) {
+	seed := append(customerSeed(), map[string]interface{}{"id": float64(5), "name": "Unscoped"})
+	store := newTestStore(t, ResourceConfig{Items: seed})
+	scope := map[string]string{"account_id": "a2"}
+
+	_, response, total := store.list(nil, scope)
+	if got := listIDs(t, response, ""); got != "3,4" || total != 2 {
+		t.Errorf("scoped list = %q (%d), want 3,4", got, total)
+	}
+	if status, _ := store.get("1", scope); status != http.StatusNotFound {
+		t.Errorf("get outside scope = %d, want 404", status)
+	}
+	if status, _ := store.get("5", scope); status != http.StatusNotFound {
+		t.Errorf("get of an item without the scope field = %d, want 404", status)
+	}
+
+	_, created := store.create(map[string]interface{}{"name": "New"}, scope)
+	if account := created.(map[string]interface{})["account_id"]; account != "a2" {
+		t.Errorf("created account_id = %v, want a2 from the path", account)
+	}
+	_, replaced := store.update("3", map[string]interface{}{"name": "Linus T"}, false, scope)
+	if account := replaced.(map[string]interface{})["account_id"]; account != "a2" {
+		t.Errorf("replaced account_id = %v, want a2 kept", account)
+	}
+}
+
+func TestParseSeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		listKey string
+		want    int
+		wantErr bool
+	}{
+		{"array", `[{"id": 1}, {"id": 2}]`, "", 2, false},
+		{"wrapped", `{"data": [{"id": 1}], "total": 1}`, "data", 1, false},
+		{"wrapped without list_key", `{"data": []}`, "", 0, true},
+		{"missing list_key", `{"data": [{"id": 1}]}`, "items", 0, true},
+		{"not json", `nope`, "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, err := parseSeed([]byte(tt.data), tt.listKey)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(items) != tt.want {
+				t.Errorf("got %d items, want %d", len(items), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// SchemaViolation describes one place where a value does not satisfy its
+// JSON schema.
+type SchemaViolation struct {
+	Location string `json:"location"`
+	Path     string `json:"path"`
+	Message  string `json:"message"`
+}
+
+// schemaValidator checks decoded JSON values against a JSON Schema (the
+// subset used by OpenAPI 3). $ref pointers are resolved against doc.
+type schemaValidator struct {
+	doc        *OpenAPIDocument
+	patterns   map[string]*regexp.Regexp
+	patternsMu sync.Mutex
+}
+
+func newSchemaValidator(doc *OpenAPIDocument) *schemaValidator {
+	return &schemaValidator{doc: doc, patterns: make(map[string]*regexp.Regexp)}
+}
+
+func (v *schemaValidator) Validate(location string, schema map[string]interface{}, value interface{}) []SchemaViolation {
+	var violations []SchemaViolation
+	v.validate(location, "", schema, value, &violations, 0)
+	return violations
+}
+
+func (v *schemaValidator) validate(location, path string, node interface{}, value interface{}, violations *[]SchemaViolation, depth int) {
+	schema := v.doc.resolve(node)
+	if schema == nil || depth > 64 {
+		return
+	}
+
+	fail := func(format string, args ...interface{}) {
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		*violations = append(*violations, SchemaViolation{Location: location, Path: p, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil && schema["nullable"] == true {
+		return
+	}
+
+	if types := schemaTypes(schema); len(types) > 0 {
+		actual := jsonType(value)
+		ok := false
+		for _, t := range types {
+			if t == actual || (t == "number" && actual == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			fail("expected %s, got %s", strings.Join(types, " or "), actual)
+			return
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range enum {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("value %s is not one of %s", compactJSON(value), compactJSON(enum))
+		}
+	}
+	if constant, ok := schema["const"]; ok && !reflect.DeepEqual(constant, value) {
+		fail("value must be %s", compactJSON(constant))
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(location, path, schema, val, violations, depth)
+	case []interface{}:
+		v.validateArray(location, path, schema, val, violations, depth)
+	case string:
+		v.validateString(schema, val, fail)
+	case float64:
+		validateNumber(schema, val, fail)
+	}
+
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			v.validate(location, path, sub, value, violations, depth+1)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range anyOf {
+			if v.matches(sub, value, depth) {
+				matched++
+				break
+			}
+		}
+		if matched == 0 {
+			fail("value does not match any of the anyOf schemas")
+		}
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matched := 0
+		for _, sub := range oneOf {
+			if v.matches(sub, value, depth) {
+				matched++
+			}
+		}
+		if matched != 1 {
+			fail("value matches %d of the oneOf schemas, expected exactly 1", matched)
+		}
+	}
+	if not, ok := schema["not"]; ok && v.matches(not, value, depth) {
+		fail("value must not match the \"not\" schema")
+	}
+}
+
+func (v *schemaValidator) matches(schema interface{}, value interface{}, depth int) bool {
+	var violations []SchemaViolation
+	v.validate("", "", schema, value, &violations, depth+1)
+	return len(violations) == 0
+}
+
+func (v *schemaValidator) validateObject(location, path string, schema map[string]interface{}, obj map[string]interface{}, violations *[]SchemaViolation, depth int) {
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	for _, name := range stringSlice(schema["required"]) {
+		if _, ok := obj[name]; !ok {
+			*violations = append(*violations, SchemaViolation{
+				Location: location,
+				Path:     path + "/" + name,
+				Message:  "required property is missing",
+			})
+		}
+	}
+
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		childPath := path + "/" + name
+		if prop, ok := properties[name]; ok {
+			v.validate(location, childPath, prop, obj[name], violations, depth+1)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*violations = append(*violations, SchemaViolation{Location: location, Path: childPath, Message: "additional property is not allowed"})
+			}
+		case map[string]interface{}:
+			v.validate(location, childPath, additional, obj[name], violations, depth+1)
+		}
+	}
+
+	if min, ok := schema["minProperties"].(float64); ok && float64(len(obj)) < min {
+		*violations = append(*violations, SchemaViolation{Location: location, Path: pathOrRoot(path), Message: fmt.Sprintf("must have at least %v properties", min)})
+	}
+	if max, ok := schema["maxProperties"].(float64); ok && float64(len(obj)) > max {
+		*violations = append(*violations, SchemaViolation{Location: location, Path: pathOrRoot(path), Message: fmt.Sprintf("must have at most %v properties", max)})
+	}
+}
+
+func (v *schemaValidator) validateArray(location, path string, schema map[string]interface{}, arr []interface{}, violations *[]SchemaViolation, depth int) {
+	if items, ok := schema["items"]; ok {
+		for i, item := range arr {
+			v.validate(location, fmt.Sprintf("%s/%d", path, i), items, item, violations, depth+1)
+		}
+	}
+
+	if min, ok := schema["minItems"].(float64); ok && float64(len(arr)) < min {
+		*violations = append(*violations, SchemaViolation{Location: location, Path: pathOrRoot(path), Message: fmt.Sprintf("must have at least %v items", min)})
+	}
+	if max, ok := schema["maxItems"].(float64); ok && float64(len(arr)) > max {
+		*violations = append(*violations, SchemaViolation{Location: location, Path: pathOrRoot(path), Message: fmt.Sprintf("must have at most %v items", max)})
+	}
+	if schema["uniqueItems"] == true {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if reflect.DeepEqual(arr[i], arr[j]) {
+					*violations = append(*violations, SchemaViolation{Location: location, Path: pathOrRoot(path), Message: fmt.Sprintf("items %d and %d are equal", i, j)})
+					return
+				}
+			}
+		}
+	}
+}
+
+var (
+	emailFormat = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuidFormat  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+func (v *schemaValidator) validateString(schema map[string]interface{}, s string, fail func(string, ...interface{})) {
+	length := float64(utf8.RuneCountInString(s))
+	if min, ok := schema["minLength"].(float64); ok && length < min {
+		fail("must be at least %v characters long", min)
+	}
+	if max, ok := schema["maxLength"].(float64); ok && length > max {
+		fail("must be at most %v characters long", max)
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok {
+		v.patternsMu.Lock()
+		re, cached := v.patterns[pattern]
+		if !cached {
+			re, _ = regexp.Compile(pattern)
+			v.patterns[pattern] = re
+		}
+		v.patternsMu.Unlock()
+		if re != nil && !re.MatchString(s) {
+			fail("does not match pattern %q", pattern)
+		}
+	}
+
+	format, _ := schema["format"].(string)
+	valid := true
+	switch format {
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, s)
+		valid = err == nil
+	case "date":
+		_, err := time.Parse("2006-01-02", s)
+		valid = err == nil
+	case "email":
+		valid = emailFormat.MatchString(s)
+	case "uuid":
+		valid = uuidFormat.MatchString(s)
+	}
+	if !valid {
+		fail("is not a valid %s", format)
+	}
+}
+
+func validateNumber(schema map[string]interface{}, n float64, fail func(string, ...interface{})) {
+	if min, ok := schema["minimum"].(float64); ok {
+		if exclusive, _ := schema["exclusiveMinimum"].(bool); exclusive && n <= min {
+			fail("must be greater than %v", min)
+		} else if n < min {
+			fail("must be greater than or equal to %v", min)
+		}
+	}
+	if min, ok := schema["exclusiveMinimum"].(float64); ok && n <= min {
+		fail("must be greater than %v", min)
+	}
+	if max, ok := schema["maximum"].(float64); ok {
+		if exclusive, _ := schema["exclusiveMaximum"].(bool); exclusive && n >= max {
+			fail("must be less than %v", max)
+		} else if n > max {
+			fail("must be less than or equal to %v", max)
+		}
+	}
+	if max, ok := schema["exclusiveMaximum"].(float64); ok && n >= max {
+		fail("must be less than %v", max)
+	}
+	if multiple, ok := schema["multipleOf"].(float64); ok && multiple > 0 {
+		if q := n / multiple; math.Abs(q-math.Round(q)) > 1e-9 {
+			fail("must be a multiple of %v", multiple)
+		}
+	}
+}
+
+// schemaTypes returns the allowed types of a schema, accepting both the
+// OpenAPI 3.0 string form and the 3.1 / JSON Schema array form.
+func schemaTypes(schema map[string]interface{}) []string {
+	switch t := schema["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		return stringSlice(t)
+	}
+	return nil
+}
+
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func stringSlice(v interface{}) []string {
+	list, _ := v.([]interface{})
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func pathOrRoot(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func compactJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ShapingConfig models a slow network for a response. TTFB holds back the
+// status line and headers; BytesPerSec then paces the body in ChunkSize
+// pieces (default a tenth of a second's worth). Jitter adds a random delay of
+// up to that many milliseconds to the TTFB and to every pause.
+type ShapingConfig struct {
+	TTFB        int `json:"ttfb_ms,omitempty"`
+	BytesPerSec int `json:"bytes_per_sec,omitempty"`
+	ChunkSize   int `json:"chunk_size,omitempty"`
+	Jitter      int `json:"jitter_ms,omitempty"`
+}
+
+func (config ShapingConfig) active() bool {
+	return config.TTFB > 0 || config.BytesPerSec > 0 || config.Jitter > 0
+}
+
+func (config ShapingConfig) validate() error {
+	if config.TTFB < 0 || config.BytesPerSec < 0 || config.ChunkSize < 0 || config.Jitter < 0 {
+		return fmt.Errorf("shaping values must not be negative")
+	}
+	return nil
+}
+
+// ParseShapingConfig builds the global shaping from SHAPE_TTFB_MS,
+// SHAPE_BYTES_PER_SEC and SHAPE_JITTER_MS.
+func ParseShapingConfig(ttfb, bytesPerSec, jitter string) (ShapingConfig, error) {
+	var config ShapingConfig
+	for _, param := range []struct {
+		name   string
+		raw    string
+		target *int
+	}{
+		{"SHAPE_TTFB_MS", ttfb, &config.TTFB},
+		{"SHAPE_BYTES_PER_SEC", bytesPerSec, &config.BytesPerSec},
+		{"SHAPE_JITTER_MS", jitter, &config.Jitter},
+	} {
+		if param.raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(param.raw)
+		if err != nil || n < 0 {
+			return config, fmt.Errorf("invalid %s %q", param.name, param.raw)
+		}
+		*param.target = n
+	}
+	return config, nil
+}
+
+// shaping returns the route's own shaping, which replaces the global one
+// ("shaping": {} turns it off for the route).
+func (ms *MockServer) shaping(route *RouteConfig) ShapingConfig {
+	if route.Shaping != nil {
+		return *route.Shaping
+	}
+	return ms.shapingDefault
+}
+
+// shapedWriter throttles an http.ResponseWriter according to a
+// ShapingConfig.
+type shapedWriter struct {
+	http.ResponseWriter
+	config  ShapingConfig
+	started bool
+}
+
+func newShapedWriter(w http.ResponseWriter, config ShapingConfig) *shapedWriter {
+	if config.ChunkSize == 0 && config.BytesPerSec > 0 {
+		config.ChunkSize = config.BytesPerSec / 10
+		if config.ChunkSize == 0 {
+			config.ChunkSize = 1
+		}
+	}
+	return &shapedWriter{ResponseWriter: w, config: config}
+}
+
+func (w *shapedWriter) jitter() time.Duration {
+	if w.config.Jitter == 0 {
+		return 0
+	}
+	return time.Duration(rand.Intn(w.config.Jitter+1)) * time.Millisecond
+}
+
+// firstByte waits out the TTFB before anything reaches the client.
+func (w *shapedWriter) firstByte() {
+	if w.started {
+		return
+	}
+	w.started = true
+	time.Sleep(time.Duration(w.config.TTFB)*time.Millisecond + w.jitter())
+}
+
+func (w *shapedWriter) WriteHeader(status int) {
+	w.firstByte()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *shapedWriter) Write(p []byte) (int, error) {
+	w.firstByte()
+	if w.config.BytesPerSec == 0 {
+		return w.ResponseWriter.Write(p)
+	}
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > w.config.ChunkSize {
+			chunk = chunk[:w.config.ChunkSize]
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.Flush()
+		time.Sleep(time.Duration(len(chunk))*time.Second/time.Duration(w.config.BytesPerSec) + w.jitter())
+	}
+	return written, nil
+}
+
+func (w *shapedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *shapedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
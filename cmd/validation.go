@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ValidationConfig attaches JSON schemas to a route's request. Body, Query
+// and Headers are either an inline schema or the path of a schema file
+// (relative to the config directory). OpenAPI and Operation take the schemas
+// from an operation in an OpenAPI document instead; inline schemas win.
+type ValidationConfig struct {
+	Body      interface{} `json:"body,omitempty"`
+	Query     interface{} `json:"query,omitempty"`
+	Headers   interface{} `json:"headers,omitempty"`
+	OpenAPI   string      `json:"openapi,omitempty"`
+	Operation string      `json:"operation,omitempty"`
+	Status    int         `json:"status,omitempty"`
+	Response  interface{} `json:"response,omitempty"`
+}
+
+type compiledSchema struct {
+	validator *schemaValidator
+	schema    map[string]interface{}
+}
+
+func (cs *compiledSchema) validate(location string, value interface{}) []SchemaViolation {
+	if cs == nil {
+		return nil
+	}
+	return cs.validator.Validate(location, cs.schema, value)
+}
+
+type requestValidator struct {
+	config *ValidationConfig
+	// err is set when the schemas could not be loaded; such a route rejects
+	// every request instead of accepting it unchecked
+	err          error
+	body         *compiledSchema
+	bodyOptional bool
+	query        *compiledSchema
+	headers      *compiledSchema
+}
+
+func compileValidation(config *ValidationConfig, baseDir string) (*requestValidator, error) {
+	rv := &requestValidator{config: config}
+
+	if config.OpenAPI != "" {
+		doc, err := LoadOpenAPIDocument(resolveConfigPath(baseDir, config.OpenAPI))
+		if err != nil {
+			return nil, err
+		}
+		op, err := doc.FindOperation(config.Operation)
+		if err != nil {
+			return nil, err
+		}
+
+		validator := newSchemaValidator(doc)
+		if schema := doc.RequestBodySchema(op); schema != nil {
+			rv.body = &compiledSchema{validator, schema}
+			required, _ := doc.resolve(op.Node["requestBody"])["required"].(bool)
+			rv.bodyOptional = !required
+		}
+		if schema := parameterSchema(doc, op, "query"); schema != nil {
+			rv.query = &compiledSchema{validator, schema}
+		}
+		if schema := parameterSchema(doc, op, "header"); schema != nil {
+			rv.headers = &compiledSchema{validator, schema}
+		}
+	}
+
+	for _, part := range []struct {
+		source interface{}
+		target **compiledSchema
+	}{
+		{config.Body, &rv.body},
+		{config.Query, &rv.query},
+		{config.Headers, &rv.headers},
+	} {
+		if part.source == nil {
+			continue
+		}
+		schema, err := loadSchema(part.source, baseDir)
+		if err != nil {
+			return nil, err
+		}
+		*part.target = &compiledSchema{newSchemaValidator(&OpenAPIDocument{root: schema}), schema}
+		if part.target == &rv.body {
+			rv.bodyOptional = false
+		}
+	}
+
+	return rv, nil
+}
+
+// loadSchema accepts an inline schema object or the path of a JSON schema file.
+func loadSchema(source interface{}, baseDir string) (map[string]interface{}, error) {
+	switch s := source.(type) {
+	case map[string]interface{}:
+		return s, nil
+	case string:
+		data, err := os.ReadFile(resolveConfigPath(baseDir, s))
+		if err != nil {
+			return nil, err
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(data, &schema); err != nil {
+			return nil, fmt.Errorf("failed to parse schema %s: %w", s, err)
+		}
+		return schema, nil
+	}
+	return nil, fmt.Errorf("schema must be an object or a file path, got %T", source)
+}
+
+func resolveConfigPath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// parameterSchema turns the operation's parameters in one location into an
+// object schema keyed by parameter name.
+func parameterSchema(doc *OpenAPIDocument, op *OpenAPIOperation, in string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []interface{}
+
+	for _, param := range op.Parameters {
+		if param["in"] != in {
+			continue
+		}
+		name, _ := param["name"].(string)
+		schema := param["schema"]
+		if schema == nil {
+			schema = map[string]interface{}{}
+		}
+		properties[name] = schema
+		if param["required"] == true {
+			required = append(required, name)
+		}
+	}
+
+	if len(properties) == 0 {
+		return nil
+	}
+	schema := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// Validate checks the request against the route's schemas. The request body
+// is restored so later handlers can read it again.
+func (rv *requestValidator) Validate(r *http.Request) []SchemaViolation {
+	var violations []SchemaViolation
+
+	if rv.body != nil {
+		var data []byte
+		if r.Body != nil {
+			data, _ = io.ReadAll(r.Body)
+			r.Body = io.NopCloser(bytes.NewBuffer(data))
+		}
+
+		trimmed := bytes.TrimSpace(data)
+		var body interface{}
+		switch {
+		case len(trimmed) == 0 && rv.bodyOptional:
+		case len(trimmed) == 0:
+			violations = append(violations, SchemaViolation{Location: "body", Path: "/", Message: "request body is required"})
+		case json.Unmarshal(trimmed, &body) != nil:
+			violations = append(violations, SchemaViolation{Location: "body", Path: "/", Message: "request body is not valid JSON"})
+		default:
+			violations = append(violations, rv.body.validate("body", body)...)
+		}
+	}
+
+	if rv.query != nil {
+		query := make(map[string]interface{})
+		properties, _ := rv.query.validator.doc.resolve(rv.query.schema)["properties"].(map[string]interface{})
+		for name, values := range r.URL.Query() {
+			query[name] = coerceParameter(rv.query.validator.doc, properties[name], values)
+		}
+		violations = append(violations, rv.query.validate("query", query)...)
+	}
+
+	if rv.headers != nil {
+		// Header names are case-insensitive, so only the headers the schema
+		// mentions are collected, under the name the schema uses.
+		headers := make(map[string]interface{})
+		schema := rv.headers.validator.doc.resolve(rv.headers.schema)
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := stringSlice(schema["required"])
+		for name := range properties {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			if values := r.Header.Values(name); len(values) > 0 {
+				headers[name] = coerceParameter(rv.headers.validator.doc, properties[name], values)
+			}
+		}
+		violations = append(violations, rv.headers.validate("headers", headers)...)
+	}
+
+	return violations
+}
+
+// coerceParameter converts raw string values to the type the schema expects
+// so that numeric and boolean parameters validate naturally.
+func coerceParameter(doc *OpenAPIDocument, node interface{}, values []string) interface{} {
+	schema := doc.resolve(node)
+	types := []string{"string"}
+	if schema != nil {
+		if t := schemaTypes(schema); len(t) > 0 {
+			types = t
+		}
+	}
+
+	if types[0] == "array" {
+		items := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			items = append(items, coerceParameter(doc, schema["items"], []string{value}))
+		}
+		return items
+	}
+
+	value := values[0]
+	switch types[0] {
+	case "integer", "number":
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// loadFailure answers a request to a route whose validation failed to load.
+func (rv *requestValidator) loadFailure(c echo.Context) error {
+	req := c.Request()
+	log.Printf("⚠️  Rejecting %s %s: validation could not be loaded: %v", req.Method, req.URL.Path, rv.err)
+	return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+		"error":   "Request validation could not be loaded",
+		"method":  req.Method,
+		"path":    req.URL.Path,
+		"message": rv.err.Error(),
+	})
+}
+
+// validationFailure writes the configured error response with a report of
+// every violation.
+func (rv *requestValidator) validationFailure(c echo.Context, violations []SchemaViolation) error {
+	req := c.Request()
+	log.Printf("⚠️  Request validation failed for %s %s (%d violations)", req.Method, req.URL.Path, len(violations))
+	for _, v := range violations {
+		log.Printf("   - %s %s: %s", v.Location, v.Path, v.Message)
+	}
+
+	status := rv.config.Status
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+
+	if custom, ok := rv.config.Response.(map[string]interface{}); ok {
+		response := make(map[string]interface{}, len(custom)+1)
+		for k, v := range custom {
+			response[k] = v
+		}
+		response["violations"] = violations
+		return c.JSON(status, response)
+	}
+	if rv.config.Response != nil {
+		return c.JSON(status, rv.config.Response)
+	}
+
+	return c.JSON(status, map[string]interface{}{
+		"error":      "Request validation failed",
+		"method":     req.Method,
+		"path":       req.URL.Path,
+		"violations": violations,
+	})
+}
+
+// ValidationRecord is a rejected request, for GET /api/validation-failures.
+type ValidationRecord struct {
+	Route      string            `json:"route"`
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Violations []SchemaViolation `json:"violations"`
+	At         time.Time         `json:"at"`
+}
+
+// maxValidationRecords bounds the history kept for
+// GET /api/validation-failures.
+const maxValidationRecords = 100
+
+// validationLog keeps the most recent validation failures.
+type validationLog struct {
+	mu      sync.Mutex
+	records []ValidationRecord
+}
+
+func (l *validationLog) add(record ValidationRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = append(l.records, record)
+	if len(l.records) > maxValidationRecords {
+		l.records = l.records[len(l.records)-maxValidationRecords:]
+	}
+}
+
+func (l *validationLog) list() []ValidationRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	records := make([]ValidationRecord, len(l.records))
+	copy(records, l.records)
+	return records
+}
+
+func (l *validationLog) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records = nil
+}
+
+// handleListValidationFailures returns recently rejected requests, oldest
+// first.
+func (ms *MockServer) handleListValidationFailures(c echo.Context) error {
+	return c.JSON(http.StatusOK, ms.validationFailures.list())
+}
+
+// handleClearValidationFailures empties the validation failure history.
+func (ms *MockServer) handleClearValidationFailures(c echo.Context) error {
+	ms.validationFailures.clear()
+	return c.NoContent(http.StatusNoContent)
+}
@@ -59,8 +59,8 @@ echo ""
 
 # Step 4: Build main binaries
 echo -e "${YELLOW}🔧 Step 4: Building main binaries...${NC}"
-go build -o mock-server cmd/main.go
-go build -o capture-proxy cmd/capture/main.go
+go build -o mock-server ./cmd
+go build -o capture-proxy ./cmd/capture
 echo -e "${GREEN}✅ Binaries built${NC}"
 echo ""
 
@@ -105,7 +105,7 @@ echo "  3. Test endpoints: ./example-app/test.sh"
 echo ""
 echo "Alternative capture methods:"
 echo "  • Transparent proxy: Already running (automatic)"
-echo "  • Standard proxy: go run cmd/capture/main.go (port 8091)"
+echo "  • Standard proxy: go run ./cmd/capture (port 8091)"
 echo ""
 echo "To view logs:"
 echo "  • All containers: docker compose -f docker-compose-transparent.yml logs -f"
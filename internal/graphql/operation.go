@@ -0,0 +1,76 @@
+// Package graphql holds the GraphQL parsing shared by the mock server and
+// the capture proxy.
+package graphql
+
+import "strings"
+
+// ParseOperation returns the name and type of the operation that will run:
+// the one called operationName, else the first in the document.
+// Shorthand documents ("{ ... }") are anonymous queries.
+func ParseOperation(query, operationName string) (name, operationType string) {
+	type definition struct{ name, operationType string }
+	var definitions []definition
+
+	// Only top-level definitions count; fields may be called "query" too
+	depth, parens, pending := 0, 0, false
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '#':
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			for i++; i < len(query) && query[i] != '"'; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+		case c == '(':
+			parens++
+		case c == ')':
+			parens--
+		case c == '{' && parens == 0:
+			if depth == 0 && !pending {
+				definitions = append(definitions, definition{"", "query"})
+			}
+			depth++
+			pending = false
+		case c == '}' && parens == 0:
+			depth--
+		case depth == 0 && parens == 0 && isNameChar(c) && (i == 0 || !isNameChar(query[i-1])):
+			word := leadingName(query[i:])
+			i += len(word) - 1
+			switch word {
+			case "query", "mutation", "subscription":
+				rest := strings.TrimLeft(query[i+1:], " \t\r\n,")
+				definitions = append(definitions, definition{leadingName(rest), word})
+				pending = true
+			case "fragment":
+				pending = true
+			}
+		}
+	}
+
+	for _, def := range definitions {
+		if operationName == "" || def.name == operationName {
+			return def.name, def.operationType
+		}
+	}
+	return "", ""
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// leadingName returns the name at the start of s, if any.
+func leadingName(s string) string {
+	end := 0
+	for end < len(s) && isNameChar(s[end]) {
+		end++
+	}
+	if end > 0 && s[0] >= '0' && s[0] <= '9' {
+		return ""
+	}
+	return s[:end]
+}
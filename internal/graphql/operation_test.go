@@ -0,0 +1,53 @@
+package graphql
+
+import "testing"
+
+func TestParseOperation(t *testing.T) {
+	tests := []struct {
+		name          string
+		query         string
+		operationName string
+		wantName      string
+		wantType      string
+	}{
+		{"named query", "query GetUser($id: ID!) { user(id: $id) { name } }", "", "GetUser", "query"},
+		{"mutation", "mutation CreateUser { createUser { id } }", "", "CreateUser", "mutation"},
+		{"subscription", "subscription OnEvent { event { id } }", "", "OnEvent", "subscription"},
+		{"shorthand", "{ user { name } }", "", "", "query"},
+		{"anonymous query", "query { user { name } }", "", "", "query"},
+		{"selected by name", "query A { a } mutation B { b }", "B", "B", "mutation"},
+		{"first without name", "query A { a } mutation B { b }", "", "A", "query"},
+		{"unknown name", "query A { a }", "C", "", ""},
+		{"field called query", "{ query { mutation } }", "", "", "query"},
+		{"fragment before operation", "fragment F on User { name } query Q { user { ...F } }", "", "Q", "query"},
+		{"comment", "# query Fake { x }\nmutation Real { y }", "", "Real", "mutation"},
+		{"string with braces", `query Q { search(text: "} mutation M {") { id } }`, "", "Q", "query"},
+		{"variables with default object", "query Q($f: Filter = {a: 1}) { items(filter: $f) { id } }", "", "Q", "query"},
+		{"comma after keyword", "query, Named { a }", "", "Named", "query"},
+		{"empty document", "", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, operationType := ParseOperation(tt.query, tt.operationName)
+			if name != tt.wantName || operationType != tt.wantType {
+				t.Errorf("ParseOperation(%q, %q) = (%q, %q), want (%q, %q)",
+					tt.query, tt.operationName, name, operationType, tt.wantName, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestLeadingName(t *testing.T) {
+	tests := map[string]string{
+		"GetUser($id)": "GetUser",
+		"_private {":   "_private",
+		"1abc":         "",
+		"":             "",
+		"{ a }":        "",
+	}
+	for input, want := range tests {
+		if got := leadingName(input); got != want {
+			t.Errorf("leadingName(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
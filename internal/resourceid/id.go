@@ -0,0 +1,25 @@
+// Package resourceid renders resource IDs the same way in the capture
+// proxy, which infers resources from captured paths, and the mock server,
+// which serves them.
+package resourceid
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Format renders an ID or filter value for comparison with a path segment
+// or query string. Strings are used as they are, numbers without a trailing
+// ".0" and other JSON values as their JSON encoding; nil is "".
+func Format(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
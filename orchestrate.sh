@@ -22,8 +22,8 @@ print_msg() {
 # Function to cleanup on exit
 cleanup() {
     print_msg "\n🧹 Cleaning up..." "$YELLOW"
-    pkill -f "cmd/capture/main.go" 2>/dev/null
-    pkill -f "cmd/main.go" 2>/dev/null
+    pkill -f "go run ./cmd/capture" 2>/dev/null
+    pkill -f "go run ./cmd$" 2>/dev/null
     unset HTTP_PROXY
     unset HTTPS_PROXY
     unset http_proxy
@@ -56,8 +56,8 @@ start_record_mode() {
     print_msg "\n📸 Starting RECORD MODE..." "$YELLOW"
     
     # Kill any existing processes
-    pkill -f "cmd/capture/main.go" 2>/dev/null
-    pkill -f "cmd/main.go" 2>/dev/null
+    pkill -f "go run ./cmd/capture" 2>/dev/null
+    pkill -f "go run ./cmd$" 2>/dev/null
     sleep 1
     
     # Start capture proxy in transparent mode
@@ -68,13 +68,13 @@ start_record_mode() {
     CAPTURE_PORT=$PROXY_PORT \
     OUTPUT_DIR=$CAPTURED_DIR \
     TRANSPARENT_MODE=true \
-    go run cmd/capture/main.go &
+    go run ./cmd/capture &
     
     sleep 2
     
     # Also start mock server for the viewer
     print_msg "Starting mock server with viewer on port $MOCK_PORT..." "$BLUE"
-    PORT=$MOCK_PORT CONFIG_PATH=$CONFIGS_DIR go run cmd/main.go &
+    PORT=$MOCK_PORT CONFIG_PATH=$CONFIGS_DIR go run ./cmd &
     sleep 2
     
     # Set proxy environment variables for ALL HTTP traffic
@@ -135,8 +135,8 @@ start_replay_mode() {
     print_msg "\n🎭 Starting REPLAY MODE..." "$YELLOW"
     
     # Kill any existing processes
-    pkill -f "cmd/capture/main.go" 2>/dev/null
-    pkill -f "cmd/main.go" 2>/dev/null
+    pkill -f "go run ./cmd/capture" 2>/dev/null
+    pkill -f "go run ./cmd$" 2>/dev/null
     sleep 1
     
     # Copy captured files to configs if they exist
@@ -148,7 +148,7 @@ start_replay_mode() {
     
     # Start mock server
     print_msg "Starting mock server on port $MOCK_PORT..." "$BLUE"
-    PORT=$MOCK_PORT CONFIG_PATH=$CONFIGS_DIR go run cmd/main.go &
+    PORT=$MOCK_PORT CONFIG_PATH=$CONFIGS_DIR go run ./cmd &
     sleep 2
     
     # Clear proxy settings (we want direct calls to mock)
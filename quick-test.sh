@@ -11,13 +11,13 @@ case "$MODE" in
     record)
         echo "🔴 RECORD MODE - Starting capture proxy..."
         # Kill existing
-        pkill -f "cmd/capture/main.go" 2>/dev/null
+        pkill -f "go run ./cmd/capture" 2>/dev/null
         
         # Start capture proxy with test API
         CAPTURE_PORT=$PROXY_PORT \
         OUTPUT_DIR=./captured \
         DEFAULT_TARGET=https://jsonplaceholder.typicode.com \
-        go run cmd/capture/main.go &
+        go run ./cmd/capture &
         
         sleep 2
         
@@ -34,19 +34,19 @@ case "$MODE" in
         echo "✅ Captured $(ls -1 captured/*.json 2>/dev/null | wc -l) files"
         
         # Kill proxy
-        pkill -f "cmd/capture/main.go"
+        pkill -f "go run ./cmd/capture"
         ;;
         
     replay)
         echo "🎭 REPLAY MODE - Starting mock server..."
         # Kill existing
-        pkill -f "cmd/main.go" 2>/dev/null
+        pkill -f "go run ./cmd$" 2>/dev/null
         
         # Copy captured to configs
         cp captured/*.json configs/ 2>/dev/null
         
         # Start mock server
-        PORT=$MOCK_PORT CONFIG_PATH=./configs go run cmd/main.go &
+        PORT=$MOCK_PORT CONFIG_PATH=./configs go run ./cmd &
         
         sleep 2
         
@@ -0,0 +1,216 @@
+#!/bin/bash
+
+# End-to-end check of the capture proxy's transparent listener.
+#
+# Builds three network namespaces - client, proxy and upstream - with the
+# proxy namespace routing between the other two. An iptables REDIRECT rule in
+# the proxy namespace sends the client's connections to TRANSPARENT_PORT, so
+# the proxy only learns where they were going from SO_ORIGINAL_DST. The
+# script then checks that:
+#
+#   1. an HTTP/1.1 request is captured
+#   2. an HTTP/1.0 request without a Host header reaches the original
+#      destination and is captured under it
+#   3. a raw TCP protocol (no Host header, no SNI) is tunnelled to the
+#      original destination and recorded
+#   4. a server-first protocol still works, after the sniff timeout
+#      (sniffTimeout, 2s) the proxy waits for the client to speak
+#
+# Needs root, ip, iptables, curl, python3 and go. Nothing outside the
+# namespaces is changed; they are removed on exit.
+#
+# Usage: sudo scripts/test-transparent-netns.sh
+
+set -euo pipefail
+
+NS_CLIENT=capture-test-client
+NS_PROXY=capture-test-proxy
+NS_UPSTREAM=capture-test-upstream
+
+CLIENT_IP=10.210.1.2
+PROXY_CLIENT_IP=10.210.1.1
+PROXY_UPSTREAM_IP=10.210.2.1
+UPSTREAM_IP=10.210.2.2
+
+HTTP_PORT=8080
+ECHO_PORT=7000
+BANNER_PORT=7001
+CAPTURE_PORT=8091
+TRANSPARENT_PORT=8092
+
+ROOT="$(cd "$(dirname "$0")/.." && pwd)"
+WORK="$(mktemp -d)"
+FAILED=0
+
+cleanup() {
+    for ns in "$NS_CLIENT" "$NS_PROXY" "$NS_UPSTREAM"; do
+        ip netns pids "$ns" 2>/dev/null | xargs -r kill 2>/dev/null || true
+        ip netns delete "$ns" 2>/dev/null || true
+    done
+    rm -rf "$WORK"
+}
+trap cleanup EXIT
+
+pass() { echo "✅ $1"; }
+fail() { echo "❌ $1"; FAILED=1; }
+
+if [ "$(id -u)" -ne 0 ]; then
+    echo "❌ Run as root (network namespaces and iptables need it)"
+    exit 1
+fi
+for tool in ip iptables curl python3 go; do
+    if ! command -v "$tool" > /dev/null; then
+        echo "❌ $tool is required"
+        exit 1
+    fi
+done
+
+echo "🔨 Building capture proxy..."
+(cd "$ROOT" && go build -o "$WORK/capture" ./cmd/capture)
+
+echo "🌐 Creating namespaces..."
+for ns in "$NS_CLIENT" "$NS_PROXY" "$NS_UPSTREAM"; do
+    ip netns add "$ns"
+    ip -n "$ns" link set lo up
+done
+
+ip link add veth-client type veth peer name veth-pclient
+ip link set veth-client netns "$NS_CLIENT"
+ip link set veth-pclient netns "$NS_PROXY"
+ip link add veth-upstream type veth peer name veth-pupstream
+ip link set veth-upstream netns "$NS_UPSTREAM"
+ip link set veth-pupstream netns "$NS_PROXY"
+
+ip -n "$NS_CLIENT" addr add "$CLIENT_IP/24" dev veth-client
+ip -n "$NS_CLIENT" link set veth-client up
+ip -n "$NS_CLIENT" route add default via "$PROXY_CLIENT_IP"
+
+ip -n "$NS_PROXY" addr add "$PROXY_CLIENT_IP/24" dev veth-pclient
+ip -n "$NS_PROXY" addr add "$PROXY_UPSTREAM_IP/24" dev veth-pupstream
+ip -n "$NS_PROXY" link set veth-pclient up
+ip -n "$NS_PROXY" link set veth-pupstream up
+ip netns exec "$NS_PROXY" sysctl -qw net.ipv4.ip_forward=1
+ip netns exec "$NS_PROXY" iptables -t nat -A PREROUTING -i veth-pclient -p tcp \
+    -m multiport --dports "$HTTP_PORT,$ECHO_PORT,$BANNER_PORT" -j REDIRECT --to-ports "$TRANSPARENT_PORT"
+
+ip -n "$NS_UPSTREAM" addr add "$UPSTREAM_IP/24" dev veth-upstream
+ip -n "$NS_UPSTREAM" link set veth-upstream up
+ip -n "$NS_UPSTREAM" route add default via "$PROXY_UPSTREAM_IP"
+
+echo "🖥️  Starting upstream servers..."
+cat > "$WORK/upstream.py" << EOF
+import http.server, socketserver, threading
+
+class Echo(socketserver.BaseRequestHandler):
+    def handle(self):
+        self.request.sendall(self.request.recv(1024))
+
+class Banner(socketserver.BaseRequestHandler):
+    def handle(self):
+        self.request.sendall(b"220 ready\n")
+
+class Reply(http.server.BaseHTTPRequestHandler):
+    def do_GET(self):
+        body = ('{"path": "%s"}' % self.path).encode()
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.send_header("Content-Length", str(len(body)))
+        self.end_headers()
+        self.wfile.write(body)
+
+socketserver.ThreadingTCPServer.allow_reuse_address = True
+for port, handler in (($ECHO_PORT, Echo), ($BANNER_PORT, Banner)):
+    server = socketserver.ThreadingTCPServer(("$UPSTREAM_IP", port), handler)
+    threading.Thread(target=server.serve_forever, daemon=True).start()
+http.server.ThreadingHTTPServer(("$UPSTREAM_IP", $HTTP_PORT), Reply).serve_forever()
+EOF
+ip netns exec "$NS_UPSTREAM" python3 "$WORK/upstream.py" > "$WORK/upstream.log" 2>&1 &
+
+echo "🎯 Starting capture proxy..."
+ip netns exec "$NS_PROXY" env CAPTURE_PORT="$CAPTURE_PORT" TRANSPARENT_PORT="$TRANSPARENT_PORT" \
+    OUTPUT_DIR="$WORK/captured" "$WORK/capture" > "$WORK/capture.log" 2>&1 &
+sleep 2
+
+client() { ip netns exec "$NS_CLIENT" "$@"; }
+captures() { ip netns exec "$NS_PROXY" curl -s "http://127.0.0.1:$CAPTURE_PORT/capture/query?$1"; }
+
+echo ""
+echo "1️⃣  HTTP/1.1 request"
+if client curl -s --max-time 5 "http://$UPSTREAM_IP:$HTTP_PORT/with-host" | grep -q with-host; then
+    pass "response relayed"
+else
+    fail "no response"
+fi
+if captures "path=/with-host" | grep -q "$UPSTREAM_IP:$HTTP_PORT"; then
+    pass "captured under $UPSTREAM_IP:$HTTP_PORT"
+else
+    fail "not captured"
+fi
+
+echo ""
+echo "2️⃣  HTTP/1.0 request without a Host header"
+response=$(client python3 - << EOF
+import socket
+s = socket.create_connection(("$UPSTREAM_IP", $HTTP_PORT), timeout=5)
+s.sendall(b"GET /no-host HTTP/1.0\r\n\r\n")
+data = b""
+while True:
+    chunk = s.recv(4096)
+    if not chunk:
+        break
+    data += chunk
+print(data.decode(errors="replace"))
+EOF
+)
+if echo "$response" | grep -q no-host; then
+    pass "reached the original destination"
+else
+    fail "no response: $response"
+fi
+if captures "path=/no-host" | grep -q "$UPSTREAM_IP:$HTTP_PORT"; then
+    pass "captured under the SO_ORIGINAL_DST address"
+else
+    fail "not captured under $UPSTREAM_IP:$HTTP_PORT"
+fi
+
+echo ""
+echo "3️⃣  Raw TCP (client speaks first)"
+reply=$(client python3 -c "
+import socket
+s = socket.create_connection(('$UPSTREAM_IP', $ECHO_PORT), timeout=5)
+s.sendall(b'ping-original-dst\n')
+print(s.recv(1024).decode().strip())
+")
+if [ "$reply" = "ping-original-dst" ]; then
+    pass "tunnelled to the original destination"
+else
+    fail "unexpected reply: $reply"
+fi
+if captures "method=CONNECT" | grep -q "$UPSTREAM_IP:$ECHO_PORT"; then
+    pass "tunnel recorded for $UPSTREAM_IP:$ECHO_PORT"
+else
+    fail "tunnel not recorded"
+fi
+
+echo ""
+echo "4️⃣  Server-first protocol"
+result=$(client python3 -c "
+import socket, time
+start = time.time()
+s = socket.create_connection(('$UPSTREAM_IP', $BANNER_PORT), timeout=10)
+banner = s.recv(1024).decode().strip()
+print('%s %.1f' % (banner, time.time() - start))
+")
+if [ "${result% *}" = "220 ready" ]; then
+    pass "banner received after ${result##* }s (the proxy waits up to 2s for the client to speak)"
+else
+    fail "unexpected banner: $result"
+fi
+
+echo ""
+if [ "$FAILED" -ne 0 ]; then
+    echo "❌ Transparent capture check failed; proxy log:"
+    cat "$WORK/capture.log"
+    exit 1
+fi
+echo "🎉 Transparent capture recovers the original destination"
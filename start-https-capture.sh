@@ -45,7 +45,7 @@ if [ -f "cmd/main.go" ]; then
     lsof -ti:8090 | xargs kill -9 2>/dev/null || true
     sleep 1
     # If we have the mock server locally, use it
-    PORT=8090 go run cmd/main.go &
+    PORT=8090 go run ./cmd &
     VIEWER_PID=$!
     echo "✅ Viewer started at http://localhost:8090/viewer"
 else
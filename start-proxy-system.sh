@@ -201,7 +201,7 @@ cleanup_existing() {
     
     # Kill local zombie processes
     pkill -f "transparent-capture.sh" 2>/dev/null || true
-    pkill -f "go run cmd/main.go" 2>/dev/null || true
+    pkill -f "go run ./cmd$" 2>/dev/null || true
     
     sleep 2
     echo -e "${GREEN}✅ Cleanup complete${NC}"
@@ -24,7 +24,7 @@ echo -e "\n${BLUE}Step 1: Starting capture proxy...${NC}"
 CAPTURE_PORT=8091 \
 OUTPUT_DIR=./captured \
 DEFAULT_TARGET=https://jsonplaceholder.typicode.com \
-go run cmd/capture/main.go &
+go run ./cmd/capture &
 PROXY_PID=$!
 sleep 2
 
@@ -73,7 +73,7 @@ echo "  ✅ Captured data copied to configs"
 
 # Step 6: Start mock server
 echo -e "\n${BLUE}Step 6: Starting mock server with captured data...${NC}"
-PORT=8090 CONFIG_PATH=./configs go run cmd/main.go &
+PORT=8090 CONFIG_PATH=./configs go run ./cmd &
 MOCK_PID=$!
 sleep 2
 